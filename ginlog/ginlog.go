@@ -0,0 +1,54 @@
+// Package ginlog adapts the LogMiddleware to the Gin framework.
+// It is a separate module, so that the users of the logging package do not depend on Gin.
+package ginlog
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tarent/go-log-middleware/v3/logging"
+)
+
+type ginContextKey struct{}
+
+// GinMiddleware returns a Gin middleware, which logs the requests like the LogMiddleware with the given options.
+func GinMiddleware(options ...logging.LogOption) gin.HandlerFunc {
+	mw := logging.NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := r.Context().Value(ginContextKey{}).(*gin.Context)
+		ginWriter := c.Writer
+		c.Request = r
+		c.Writer = &responseWriter{ResponseWriter: ginWriter, w: w}
+		defer func() { c.Writer = ginWriter }()
+		c.Next()
+
+		// gin sets the status of unmatched routes, e.g. 404 or 405, without writing it until the chain returns
+		if !ginWriter.Written() {
+			w.WriteHeader(ginWriter.Status())
+		}
+	}), options...)
+
+	return func(c *gin.Context) {
+		r := c.Request.WithContext(context.WithValue(c.Request.Context(), ginContextKey{}, c))
+		mw.ServeHTTP(c.Writer, r)
+	}
+}
+
+// responseWriter passes the writes of the Gin handlers through the writer of the LogMiddleware,
+// so that it sees the status and size of the response.
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.w.WriteHeader(statusCode)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	return rw.w.Write(b)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	return rw.w.Write([]byte(s))
+}
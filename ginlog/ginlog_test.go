@@ -0,0 +1,85 @@
+package ginlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarent/go-log-middleware/v3/logging"
+)
+
+func Test_GinMiddleware(t *testing.T) {
+	a := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	a.NoError(logging.SetWithOutput("info", false, b))
+	defer logging.Set("info", false)
+
+	// and a gin engine with the middleware
+	engine := gin.New()
+	engine.Use(GinMiddleware(logging.WithHandlerName("gin")))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		c.String(404, "user %v not found", c.Param("id"))
+	})
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/users/42", nil)
+	rw := httptest.NewRecorder()
+	engine.ServeHTTP(rw, r)
+
+	// then: the response is written
+	a.Equal(404, rw.Code)
+	a.Equal("user 42 not found", rw.Body.String())
+
+	// and the access is logged with the status of gin
+	data := map[string]interface{}{}
+	a.NoError(json.Unmarshal(b.Bytes(), &data))
+	a.Equal("access", data["type"])
+	a.Equal("/users/42", data["url"])
+	a.Equal(404.0, data["response_status"])
+	a.Equal(17.0, data["response_size"])
+	a.Equal("gin", data["handler"])
+	a.NotEmpty(data["correlation_id"])
+}
+
+func Test_GinMiddleware_NoRoute(t *testing.T) {
+	a := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	a.NoError(logging.SetWithOutput("info", false, b))
+	defer logging.Set("info", false)
+
+	// and a gin engine with the middleware, which handles not allowed methods
+	engine := gin.New()
+	engine.HandleMethodNotAllowed = true
+	engine.Use(GinMiddleware())
+	engine.GET("/users/:id", func(c *gin.Context) {
+		c.String(200, "user %v", c.Param("id"))
+	})
+
+	for path, status := range map[string]int{"/nope": 404, "/users/42": 405} {
+		b.Reset()
+
+		// when: a request without matching route is served
+		r, _ := http.NewRequest("POST", "http://www.example.org"+path, nil)
+		rw := httptest.NewRecorder()
+		engine.ServeHTTP(rw, r)
+
+		// then: the client gets the status of gin
+		a.Equal(status, rw.Code)
+
+		// and the access is logged with the same status
+		data := map[string]interface{}{}
+		a.NoError(json.Unmarshal(b.Bytes(), &data))
+		a.Equal(path, data["url"])
+		a.Equal(float64(status), data["response_status"])
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/tarent/go-log-middleware/v3/ginlog
+
+go 1.15
+
+require (
+	github.com/gin-gonic/gin v1.7.7
+	github.com/stretchr/testify v1.7.0
+	github.com/tarent/go-log-middleware/v3 v3.0.0
+)
+
+replace github.com/tarent/go-log-middleware/v3 => ../
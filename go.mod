@@ -1,8 +1,9 @@
 module github.com/tarent/go-log-middleware/v3
 
-go 1.13
+go 1.15
 
 require (
 	github.com/sirupsen/logrus v1.4.2
-	github.com/stretchr/testify v1.2.2
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel/trace v1.0.0
 )
@@ -0,0 +1,482 @@
+package logging
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AccessRecord contains the computed fields of an access log entry.
+type AccessRecord struct {
+	Type                   string
+	RemoteIp               string
+	Host                   string
+	Port                   string
+	URL                    string
+	QueryLength            int
+	Route                  string
+	Handler                string
+	Method                 string
+	Proto                  string
+	TLSVersion             string
+	TLSCipher              string
+	Duration               time.Duration
+	DeadlineRemaining      *time.Duration
+	UserAgent              string
+	RequestSize            int64
+	ResponseStatus         int
+	ResponseSize           int // not logged for HEAD requests, as their responses never have a body
+	ResponseHeaders        map[string]string
+	ContentEncoding        string
+	CorrelationId          string
+	CorrelationIdGenerated *bool
+	UserCorrelationId      string
+	PropagatedIds          map[string]string
+	RequestId              string
+	TraceId                string
+	SpanId                 string
+	Cookies                map[string]string
+	CookiesOmitted         int
+	Headers                map[string]string
+	ApiVersion             string
+	RequestCacheControl    string
+	ResponseCacheControl   string
+	PanicCategory          string
+	Stack                  string
+	RequestBody            string
+	Baggage                map[string]string
+	RateLimit              *RateLimit
+	Hijacked               bool
+	ClientDisconnected     bool
+	Slow                   bool
+	CustomFields           logrus.Fields
+	Error                  error
+}
+
+// NewAccessRecord computes the access record for a request.
+// The error is set for requests which could not be completed, e.g. because of a panic.
+func NewAccessRecord(r *http.Request, start time.Time, statusCode int, err error) AccessRecord {
+	record := AccessRecord{
+		Type:              "access",
+		RemoteIp:          getRemoteIp(r),
+		Host:              getHost(r),
+		URL:               buildFullPath(r),
+		QueryLength:       len(r.URL.RawQuery),
+		Method:            r.Method,
+		Proto:             r.Proto,
+		Duration:          Now().Sub(start),
+		UserAgent:         r.Header.Get("User-Agent"),
+		RequestSize:       r.ContentLength,
+		ResponseStatus:    statusCode,
+		CorrelationId:     GetCorrelationId(r.Header),
+		UserCorrelationId: GetUserCorrelationId(r.Header),
+		PropagatedIds:     getPropagatedIds(r.Header),
+		Error:             err,
+	}
+	if host, port := splitHostPort(record.Host); port != "" {
+		record.Port = port
+		if AccessLogSplitHostPort {
+			record.Host = host
+		}
+	}
+	record.TraceId, record.SpanId = getTraceIds(r.Context())
+	record.Route = getRoute(r.Context())
+	if deadline, ok := r.Context().Deadline(); ok {
+		remaining := deadline.Sub(Now())
+		record.DeadlineRemaining = &remaining
+	}
+	if r.TLS != nil {
+		record.TLSVersion = tlsVersionName(r.TLS.Version)
+		record.TLSCipher = tls.CipherSuiteName(r.TLS.CipherSuite)
+	}
+	if lc := getLogContext(r.Context()); lc != nil {
+		record.CustomFields = lc.customFields()
+		if record.Error == nil {
+			record.Error = lc.requestError()
+		}
+	}
+	if RemoteIpEnricher != nil {
+		for k, v := range RemoteIpEnricher(record.RemoteIp) {
+			if record.CustomFields == nil {
+				record.CustomFields = logrus.Fields{}
+			}
+			record.CustomFields[k] = v
+		}
+	}
+
+	if AccessLogWithCookies && !(AccessLogCookiesOnlyOnFailure && isSuccess(statusCode)) {
+		record.Cookies, record.CookiesOmitted = loggedCookies(r)
+	}
+
+	record.Headers = loggedHeaders(r.Header)
+
+	return record
+}
+
+// splitHostPort returns the host and the port, which is empty if the host does not contain one
+func splitHostPort(hostPort string) (string, string) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort, ""
+	}
+	return host, port
+}
+
+// loggedCookies returns the logged cookies of the request, which is nil if none is logged,
+// and the number of cookies omitted by the blacklist and the whitelist
+func loggedCookies(r *http.Request) (map[string]string, int) {
+	var cookies map[string]string
+	omitted := 0
+	blacklist := accessLogCookiesBlacklist()
+	for _, c := range r.Cookies() {
+		if contains(blacklist, c.Name) ||
+			len(AccessLogCookiesWhitelist) > 0 && !contains(AccessLogCookiesWhitelist, c.Name) {
+			omitted++
+			continue
+		}
+		if cookies == nil {
+			cookies = map[string]string{}
+		}
+		if contains(MaskedCookies, c.Name) {
+			cookies[c.Name] = "*****"
+		} else {
+			cookies[c.Name] = c.Value
+		}
+	}
+	return cookies, omitted
+}
+
+func loggedHeaders(h http.Header) map[string]string {
+	var headers map[string]string
+	for _, name := range LogRequestHeaders {
+		key := http.CanonicalHeaderKey(name)
+		values := h[key]
+		if len(values) == 0 {
+			continue
+		}
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		if containsHeader(RedactedHeaders, key) {
+			headers[key] = "*****"
+		} else {
+			headers[key] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+func containsHeader(s []string, name string) bool {
+	for _, a := range s {
+		if http.CanonicalHeaderKey(a) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields converts the record into the logrus fields of the access log entry
+func (record AccessRecord) Fields() logrus.Fields {
+	fields := logrus.Fields{
+		"type":       record.Type,
+		"remote_ip":  record.RemoteIp,
+		"host":       record.Host,
+		"url":        record.URL,
+		"method":     record.Method,
+		"proto":      record.Proto,
+		"duration":   record.Duration.Nanoseconds() / 1000000,
+		"User_Agent": record.UserAgent,
+	}
+
+	setDurationMicros(fields, record.Duration)
+
+	// the remaining time is negative, if the deadline was exceeded
+	if record.DeadlineRemaining != nil {
+		fields["deadline_ms"] = record.DeadlineRemaining.Nanoseconds() / 1000000
+	}
+
+	if record.Port != "" {
+		fields["port"] = record.Port
+	}
+
+	if record.QueryLength > 0 {
+		fields["query_length"] = record.QueryLength
+	}
+
+	if record.Route != "" {
+		fields["route"] = record.Route
+	}
+
+	if record.Handler != "" {
+		fields["handler"] = record.Handler
+	}
+
+	if record.TLSVersion != "" {
+		fields["tls_version"] = record.TLSVersion
+		fields["tls_cipher"] = record.TLSCipher
+	}
+
+	// the request size is -1 if unknown
+	if record.RequestSize >= 0 {
+		fields["request_size"] = record.RequestSize
+	}
+
+	if record.ResponseStatus != 0 {
+		fields["response_status"] = record.ResponseStatus
+		setStatusText(fields, record.ResponseStatus)
+		// responses to HEAD requests never have a body
+		if record.Method != http.MethodHead {
+			fields["response_size"] = record.ResponseSize
+		}
+	}
+
+	if record.Error != nil {
+		fields[logrus.ErrorKey] = record.Error.Error()
+	}
+
+	if record.Hijacked {
+		fields["hijacked"] = true
+	}
+
+	if record.ClientDisconnected {
+		fields["client_disconnected"] = true
+	}
+
+	if record.Slow {
+		fields["slow"] = true
+	}
+
+	if record.PanicCategory != "" {
+		fields["panic_category"] = record.PanicCategory
+	}
+
+	if record.Stack != "" {
+		fields["stack"] = record.Stack
+	}
+
+	if record.RequestBody != "" {
+		fields["request_body"] = record.RequestBody
+	}
+
+	setRecordCorrelationIds(fields, record.CorrelationId, record.UserCorrelationId)
+	setTraceIds(fields, record.TraceId, record.SpanId)
+
+	// only set by the LogMiddleware, which knows whether it generated the correlation id
+	if record.CorrelationIdGenerated != nil {
+		fields["correlation_id_generated"] = *record.CorrelationIdGenerated
+	}
+
+	if record.RequestId != "" {
+		fields["request_id"] = record.RequestId
+	}
+
+	// a nil or empty map of cookies is never logged
+	if len(record.Cookies) > 0 {
+		fields["cookies"] = record.Cookies
+	}
+
+	// signals, that the cookies field is absent or incomplete, because cookies were filtered
+	if record.CookiesOmitted > 0 {
+		fields["cookies_omitted"] = record.CookiesOmitted
+	}
+
+	if len(record.Headers) > 0 {
+		fields["headers"] = record.Headers
+	}
+
+	if len(record.ResponseHeaders) > 0 {
+		fields["response_headers"] = record.ResponseHeaders
+	}
+
+	if record.ContentEncoding != "" {
+		fields["content_encoding"] = record.ContentEncoding
+	}
+
+	if record.ApiVersion != "" {
+		fields["api_version"] = record.ApiVersion
+	}
+
+	if len(record.Baggage) > 0 {
+		fields["baggage"] = record.Baggage
+	}
+
+	if record.RateLimit != nil {
+		fields["rate_limit"] = record.RateLimit.Limit
+		fields["rate_limit_remaining"] = record.RateLimit.Remaining
+		if !record.RateLimit.Reset.IsZero() {
+			fields["rate_limit_reset"] = record.RateLimit.Reset.Format(time.RFC3339)
+		}
+		fields["throttled"] = record.RateLimit.Throttled
+	}
+
+	if record.RequestCacheControl != "" {
+		fields["request_cache_control"] = record.RequestCacheControl
+	}
+	if record.ResponseCacheControl != "" {
+		fields["response_cache_control"] = record.ResponseCacheControl
+	}
+
+	setPropagatedIds(fields, record.PropagatedIds)
+
+	for k, v := range record.CustomFields {
+		if _, exists := fields[k]; !exists {
+			fields[k] = v
+		}
+	}
+
+	return fields
+}
+
+// CallRecord contains the computed fields of a call log entry.
+type CallRecord struct {
+	Host              string
+	URL               string
+	FullURL           string
+	Method            string
+	Proto             string
+	Duration          time.Duration
+	UserAgent         string
+	CorrelationId     string
+	UserCorrelationId string
+	PropagatedIds     map[string]string
+	TraceId           string
+	SpanId            string
+	RequestBody       string
+	CallId            string
+	Attempt           int
+	Conn              *ConnInfo
+	ResponseStatus    int
+	ContentType       string
+	Error             error
+}
+
+// NewCallRecord computes the call record for an outgoing request.
+// The response fields are only set if no error is given.
+func NewCallRecord(r *http.Request, resp *http.Response, start time.Time, err error) CallRecord {
+	path := fullPath(r)
+	record := CallRecord{
+		Host:              r.Host,
+		URL:               truncateUrl(path),
+		FullURL:           buildFullUrlWithPath(r, path),
+		Method:            r.Method,
+		Proto:             r.Proto,
+		Duration:          Now().Sub(start),
+		UserAgent:         r.Header.Get("User-Agent"),
+		CorrelationId:     GetCorrelationId(r.Header),
+		UserCorrelationId: GetUserCorrelationId(r.Header),
+		PropagatedIds:     getPropagatedIds(r.Header),
+		Error:             err,
+	}
+	record.TraceId, record.SpanId = getTraceIds(r.Context())
+	record.Conn = getConnInfo(r.Context())
+
+	if CallBodyCapture {
+		record.RequestBody = captureRequestBody(r)
+	}
+
+	if err == nil && resp != nil {
+		// the transport negotiates the protocol, the proto of the request is always the default HTTP/1.1
+		record.Proto = resp.Proto
+		record.ResponseStatus = resp.StatusCode
+		record.ContentType = resp.Header.Get("Content-Type")
+	}
+
+	return record
+}
+
+// Fields converts the record into the logrus fields of the call log entry
+func (record CallRecord) Fields() logrus.Fields {
+	fields := logrus.Fields{
+		"type":     "call",
+		"host":     record.Host,
+		"url":      record.URL,
+		"full_url": record.FullURL,
+		"method":   record.Method,
+		"duration": record.Duration.Nanoseconds() / 1000000,
+	}
+
+	setDurationMicros(fields, record.Duration)
+
+	if record.Proto != "" {
+		fields["proto"] = record.Proto
+	}
+
+	if record.UserAgent != "" {
+		fields["User_Agent"] = record.UserAgent
+	}
+
+	setRecordCorrelationIds(fields, record.CorrelationId, record.UserCorrelationId)
+	setTraceIds(fields, record.TraceId, record.SpanId)
+
+	if record.RequestBody != "" {
+		fields["request_body"] = record.RequestBody
+	}
+
+	if record.Attempt > 0 {
+		fields["attempt"] = record.Attempt
+	}
+
+	if record.CallId != "" {
+		fields["call_id"] = record.CallId
+	}
+
+	if record.Conn != nil {
+		fields["conn_reused"] = record.Conn.Reused
+		fields["conn_was_idle"] = record.Conn.WasIdle
+		fields["conn_wait"] = record.Conn.Wait.Nanoseconds() / 1000000
+	}
+
+	if record.Error != nil {
+		fields[logrus.ErrorKey] = record.Error.Error()
+	}
+
+	if record.ResponseStatus != 0 {
+		fields["response_status"] = record.ResponseStatus
+		setStatusText(fields, record.ResponseStatus)
+		fields["content_type"] = record.ContentType
+	}
+
+	setPropagatedIds(fields, record.PropagatedIds)
+
+	return fields
+}
+
+func setDurationMicros(fields logrus.Fields, duration time.Duration) {
+	if LogDurationMicros {
+		fields["duration_us"] = duration.Nanoseconds() / 1000
+	}
+}
+
+// setStatusText sets the text of the status code, e.g. Not Found, if it is a known status code
+func setStatusText(fields logrus.Fields, statusCode int) {
+	if text := http.StatusText(statusCode); text != "" {
+		fields["response_status_text"] = text
+	}
+}
+
+// setPropagatedIds sets the propagated ids, skipping names which collide with fields already set
+// or the fields of the Logger, so that e.g. correlation_id or type are never overwritten
+func setPropagatedIds(fields logrus.Fields, ids map[string]string) {
+	for field, value := range ids {
+		if _, exists := fields[field]; exists {
+			continue
+		}
+		if _, exists := GetLogger().Data[field]; exists {
+			continue
+		}
+		fields[field] = value
+	}
+}
+
+func setRecordCorrelationIds(fields logrus.Fields, correlationId, userCorrelationId string) {
+	if correlationId != "" {
+		fields["correlation_id"] = correlationId
+	}
+	if userCorrelationId != "" {
+		fields["user_correlation_id"] = userCorrelationId
+	}
+}
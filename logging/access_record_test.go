@@ -0,0 +1,336 @@
+package logging
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewAccessRecord(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo?q=bar", nil)
+	r.Header = http.Header{
+		CorrelationIdHeader: {"correlation-123"},
+		"Cookie":            {"foo=bar;"},
+		"User-Agent":        {"agent"},
+	}
+	r.RemoteAddr = "127.0.0.1:1234"
+
+	// when: the record is computed
+	record := NewAccessRecord(r, time.Now().Add(-1*time.Second), 201, nil)
+
+	// then: all fields match
+	a.Equal("127.0.0.1", record.RemoteIp)
+	a.Equal("www.example.org", record.Host)
+	a.Equal("/foo?q=bar", record.URL)
+	a.Equal("GET", record.Method)
+	a.Equal("agent", record.UserAgent)
+	a.Equal(201, record.ResponseStatus)
+	a.Equal("correlation-123", record.CorrelationId)
+	a.Equal(map[string]string{"foo": "bar"}, record.Cookies)
+	a.InDelta(time.Second, record.Duration, float64(50*time.Millisecond))
+
+	// and: the fields are converted for logrus
+	fields := record.Fields()
+	a.Equal("access", fields["type"])
+	a.Equal(201, fields["response_status"])
+	a.Equal("correlation-123", fields["correlation_id"])
+	a.NotContains(fields, "user_correlation_id")
+	a.NotContains(fields, logrus.ErrorKey)
+}
+
+func Test_NewAccessRecord_TLS(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a https request
+	r, _ := http.NewRequest("GET", "https://www.example.org/foo", nil)
+	r.TLS = &tls.ConnectionState{
+		Version:     tls.VersionTLS13,
+		CipherSuite: tls.TLS_AES_128_GCM_SHA256,
+	}
+
+	// when: the record is computed
+	fields := NewAccessRecord(r, time.Now(), 200, nil).Fields()
+
+	// then: the tls details are contained
+	a.Equal("TLS 1.3", fields["tls_version"])
+	a.Equal("TLS_AES_128_GCM_SHA256", fields["tls_cipher"])
+
+	// when: the record of a plain http request is computed
+	r.TLS = nil
+	fields = NewAccessRecord(r, time.Now(), 200, nil).Fields()
+
+	// then: the tls details are omitted
+	a.NotContains(fields, "tls_version")
+	a.NotContains(fields, "tls_cipher")
+}
+
+func Test_NewAccessRecord_HostPort(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request to a host with port
+	r, _ := http.NewRequest("GET", "http://www.example.org:8080/foo", nil)
+
+	// when: the record is computed
+	record := NewAccessRecord(r, time.Now(), 200, nil)
+
+	// then: the combined host is kept and the port is logged separately
+	a.Equal("www.example.org:8080", record.Host)
+	a.Equal("8080", record.Port)
+	fields := record.Fields()
+	a.Equal("www.example.org:8080", fields["host"])
+	a.Equal("8080", fields["port"])
+}
+
+func Test_NewAccessRecord_SplitHostPort(t *testing.T) {
+	a := assert.New(t)
+
+	// given: the host and port are split
+	AccessLogSplitHostPort = true
+	defer func() { AccessLogSplitHostPort = false }()
+
+	// when: the record of a request to a host with port is computed
+	r, _ := http.NewRequest("GET", "http://www.example.org:8080/foo", nil)
+	record := NewAccessRecord(r, time.Now(), 200, nil)
+
+	// then: the host is logged without port
+	a.Equal("www.example.org", record.Fields()["host"])
+	a.Equal("8080", record.Fields()["port"])
+
+	// when: the port of an ipv6 host is split
+	r.Host = "[::1]:8443"
+	record = NewAccessRecord(r, time.Now(), 200, nil)
+
+	// then: the host is logged without brackets
+	a.Equal("::1", record.Host)
+	a.Equal("8443", record.Port)
+}
+
+func Test_NewAccessRecord_HostOnly(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request to a host without port
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: the record is computed
+	record := NewAccessRecord(r, time.Now(), 200, nil)
+
+	// then: the port is omitted
+	a.Equal("www.example.org", record.Host)
+	a.Equal("", record.Port)
+	a.NotContains(record.Fields(), "port")
+}
+
+func Test_NewAccessRecord_CookiesBlacklisted(t *testing.T) {
+	a := assert.New(t)
+
+	// given: all cookies of the request are blacklisted
+	SetAccessLogCookiesBlacklist([]string{"foo", "bar"})
+	defer SetAccessLogCookiesBlacklist(nil)
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("Cookie", "foo=1; bar=2")
+
+	// when: the record is computed
+	record := NewAccessRecord(r, time.Now(), 500, nil)
+
+	// then: no cookies are set
+	a.Nil(record.Cookies)
+	a.Equal(2, record.CookiesOmitted)
+
+	// and: the cookies field is absent, but the omitted cookies are signaled
+	fields := record.Fields()
+	a.NotContains(fields, "cookies")
+	a.Equal(2, fields["cookies_omitted"])
+}
+
+func Test_NewAccessRecord_NoCookies(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request without cookies
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: the record is computed
+	record := NewAccessRecord(r, time.Now(), 500, nil)
+
+	// then: neither cookies nor omitted cookies are logged
+	a.Nil(record.Cookies)
+	fields := record.Fields()
+	a.NotContains(fields, "cookies")
+	a.NotContains(fields, "cookies_omitted")
+
+	// and: an empty map of cookies is not logged either
+	record.Cookies = map[string]string{}
+	a.NotContains(record.Fields(), "cookies")
+}
+
+func Test_NewCallRecord(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request and a response
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	resp := &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+	}
+
+	// when: the record is computed
+	record := NewCallRecord(r, resp, time.Now(), nil)
+
+	// then: the response fields are set
+	a.Equal("http://www.example.org/foo", record.FullURL)
+	a.Equal(200, record.ResponseStatus)
+	a.Equal("text/html", record.ContentType)
+	a.Equal("text/html", record.Fields()["content_type"])
+
+	// when: the record is computed for an error
+	record = NewCallRecord(r, resp, time.Now(), errors.New("oops"))
+
+	// then: the response fields are omitted
+	a.Equal(0, record.ResponseStatus)
+	a.Equal("oops", record.Fields()[logrus.ErrorKey])
+	a.NotContains(record.Fields(), "response_status")
+}
+
+func Test_NewCallRecord_Proto(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a server supporting HTTP/2
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// when: a call is sent to it
+	r, _ := http.NewRequest("GET", server.URL+"/foo", nil)
+	resp, err := server.Client().Do(r)
+	a.NoError(err)
+	resp.Body.Close()
+	record := NewCallRecord(r, resp, time.Now(), nil)
+
+	// then: the negotiated proto of the response is logged
+	a.Equal("HTTP/1.1", r.Proto)
+	a.Equal("HTTP/2.0", record.Proto)
+	a.Equal("HTTP/2.0", record.Fields()["proto"])
+
+	// when: the call failed
+	record = NewCallRecord(r, nil, time.Now(), errors.New("connection refused"))
+
+	// then: the proto of the request is logged
+	a.Equal("HTTP/1.1", record.Fields()["proto"])
+
+	// when: the request has no proto
+	r.Proto = ""
+	record = NewCallRecord(r, nil, time.Now(), errors.New("connection refused"))
+
+	// then: the proto is omitted
+	a.NotContains(record.Fields(), "proto")
+}
+
+func Test_AccessRecord_DurationMicros(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a record of a fast request
+	record := AccessRecord{Duration: 350 * time.Microsecond}
+
+	// then: only the milliseconds are logged by default
+	fields := record.Fields()
+	a.Equal(int64(0), fields["duration"])
+	a.NotContains(fields, "duration_us")
+
+	// when: the microseconds are enabled
+	LogDurationMicros = true
+	defer func() { LogDurationMicros = false }()
+
+	// then: they are logged in addition
+	fields = record.Fields()
+	a.Equal(int64(0), fields["duration"])
+	a.Equal(int64(350), fields["duration_us"])
+	a.Equal(int64(1500), CallRecord{Duration: 1500 * time.Microsecond}.Fields()["duration_us"])
+}
+
+func Test_NewAccessRecord_Deadline(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a fixed clock
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	Now = func() time.Time { return now }
+	defer func() { Now = time.Now }()
+
+	// and a request without deadline
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// then: no deadline is logged
+	a.NotContains(NewAccessRecord(r, now, 200, nil).Fields(), "deadline_ms")
+
+	// when: the request has a deadline
+	ctx, cancel := context.WithDeadline(r.Context(), now.Add(1500*time.Millisecond))
+	defer cancel()
+	record := NewAccessRecord(r.WithContext(ctx), now, 200, nil)
+
+	// then: the remaining time is logged
+	a.Equal(int64(1500), record.Fields()["deadline_ms"])
+}
+
+func Test_NewAccessRecord_QueryLength(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request with a query
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo?q=b%C3%A4r&x=1", nil)
+
+	// then: the byte count of the raw query is logged
+	a.Equal(14, NewAccessRecord(r, time.Now(), 200, nil).Fields()["query_length"])
+
+	// and: it is omitted for requests without query
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	a.NotContains(NewAccessRecord(r, time.Now(), 200, nil).Fields(), "query_length")
+}
+
+func Test_NewAccessRecord_RemoteIpEnricher(t *testing.T) {
+	a := assert.New(t)
+
+	// given: an enricher resolving the country
+	RemoteIpEnricher = func(ip string) logrus.Fields {
+		if ip == "192.0.2.1" {
+			return logrus.Fields{"geo_country": "DE", "host": "ignored"}
+		}
+		return nil
+	}
+	defer func() { RemoteIpEnricher = nil }()
+
+	// and a request
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	// when: the record is computed
+	fields := NewAccessRecord(r, time.Now(), 200, nil).Fields()
+
+	// then: the enriched fields are merged without overriding standard fields
+	a.Equal("DE", fields["geo_country"])
+	a.Equal("www.example.org", fields["host"])
+
+	// and: nothing is added for unknown ips
+	r.RemoteAddr = "198.51.100.1:1234"
+	a.NotContains(NewAccessRecord(r, time.Now(), 200, nil).Fields(), "geo_country")
+}
+
+func Test_Record_StatusText(t *testing.T) {
+	a := assert.New(t)
+
+	// the text of known status codes is logged
+	a.Equal("Not Found", AccessRecord{ResponseStatus: 404}.Fields()["response_status_text"])
+	a.Equal("Not Found", CallRecord{ResponseStatus: 404}.Fields()["response_status_text"])
+
+	// and omitted for nonstandard codes or without status
+	a.NotContains(AccessRecord{ResponseStatus: 599}.Fields(), "response_status_text")
+	a.NotContains(CallRecord{ResponseStatus: 599}.Fields(), "response_status_text")
+	a.NotContains(AccessRecord{}.Fields(), "response_status_text")
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BaggageHeader is the header carrying the W3C baggage, as propagated e.g. by OpenTelemetry.
+var BaggageHeader = "baggage"
+
+// GetBaggage returns the baggage entries of the request for the given keys.
+// Entries which are not contained in the baggage are omitted.
+func GetBaggage(h http.Header, keys []string) map[string]string {
+	baggage := map[string]string{}
+	for _, header := range h[http.CanonicalHeaderKey(BaggageHeader)] {
+		for _, member := range strings.Split(header, ",") {
+			// strip the optional properties
+			member = strings.SplitN(member, ";", 2)[0]
+			kv := strings.SplitN(member, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key := strings.TrimSpace(kv[0])
+			if !contains(keys, key) {
+				continue
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(kv[1]))
+			if err != nil {
+				continue
+			}
+			baggage[key] = value
+		}
+	}
+	return baggage
+}
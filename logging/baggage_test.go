@@ -0,0 +1,19 @@
+package logging
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetBaggage(t *testing.T) {
+	a := assert.New(t)
+
+	h := http.Header{
+		"Baggage": {"tier=gold, region=eu%20west;ttl=60", "other=ignored,invalid"},
+	}
+
+	a.Equal(map[string]string{"tier": "gold", "region": "eu west"}, GetBaggage(h, []string{"tier", "region", "missing"}))
+	a.Equal(map[string]string{}, GetBaggage(http.Header{}, []string{"tier"}))
+}
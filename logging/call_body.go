@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// If set, the body of outgoing requests is logged by Call.
+// Only JSON and url encoded form bodies are logged, other bodies can not be redacted and are replaced by a marker.
+// The body is read from the copy provided by GetBody of the request, bodies without GetBody are not captured.
+var CallBodyCapture = false
+
+// List of JSON and form fields in captured request bodies of calls and access logs whose values should be anonymized
+var CallBodyRedactedFields []string
+
+// Maximum size of captured request bodies, larger bodies are not logged
+var CallBodyMaxSize = 4096
+
+// Maximum size of request bodies captured by the LogMiddleware, larger bodies are not logged
+var RequestBodyMaxSize = 4096
+
+// captureRequestBody returns the body of the outgoing request with the configured fields redacted.
+// The body is only read from a copy provided by GetBody, because the body of an outgoing request
+// may already be consumed by the transport. Bodies without such a copy are replaced by a marker,
+// as well as bodies which can not be read or can not be redacted.
+func captureRequestBody(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	if r.GetBody == nil {
+		return "(not captured)"
+	}
+
+	rc, err := r.GetBody()
+	if err != nil {
+		return "(not readable)"
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(rc, int64(CallBodyMaxSize)+1))
+	if err != nil {
+		return "(not readable)"
+	}
+
+	redacted, ok := redactBody(r, body, CallBodyMaxSize)
+	if !ok {
+		return "(not redactable)"
+	}
+	return redacted
+}
+
+// captureBody returns the body of the incoming request with the configured fields redacted and whether it could be redacted.
+// The read part of the body is restored, so that the complete body can be read again afterwards.
+func captureBody(r *http.Request, maxSize int) (string, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(maxSize)+1))
+	r.Body = &restoredBody{
+		Reader: io.MultiReader(bytes.NewReader(body), r.Body),
+		Closer: r.Body,
+	}
+	if err != nil {
+		return "(not readable)", true
+	}
+	return redactBody(r, body, maxSize)
+}
+
+// redactBody returns the body with the configured fields redacted and whether it could be redacted.
+// Bodies larger than maxSize are replaced by a marker.
+func redactBody(r *http.Request, body []byte, maxSize int) (string, bool) {
+	if len(body) > maxSize {
+		return fmt.Sprintf("(exceeds %d bytes)", maxSize), true
+	}
+
+	if isFormContentType(r.Header.Get("Content-Type")) {
+		return redactForm(body)
+	}
+	return redactJSON(body)
+}
+
+// isFormContentType returns true for url encoded form bodies
+func isFormContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// isRedactable returns true, if bodies of the media type can be redacted, which are JSON and form bodies
+func isRedactable(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+// redactJSON replaces the values of the redacted fields with *****.
+// Bodies which are no valid JSON are returned as they are, with false.
+func redactJSON(body []byte) (string, bool) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return string(body), false
+	}
+
+	redacted, err := json.Marshal(redactValue(data))
+	if err != nil {
+		return string(body), false
+	}
+	return string(redacted), true
+}
+
+// redactForm replaces the values of the redacted fields of an url encoded form with *****.
+// Bodies which are no valid form are returned as they are, with false.
+func redactForm(body []byte) (string, bool) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return string(body), false
+	}
+	for key := range form {
+		if containsFold(CallBodyRedactedFields, key) {
+			form[key] = []string{"*****"}
+		}
+	}
+	redacted, _ := url.QueryUnescape(form.Encode())
+	return redacted, true
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if containsFold(CallBodyRedactedFields, key) {
+				v[key] = "*****"
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return value
+}
+
+func containsFold(s []string, e string) bool {
+	for _, a := range s {
+		if strings.EqualFold(a, e) {
+			return true
+		}
+	}
+	return false
+}
+
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,229 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Call_BodyCapture(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	CallBodyRedactedFields = []string{"password", "Token"}
+	defer func() {
+		CallBodyCapture = false
+		CallBodyRedactedFields = nil
+	}()
+
+	// and a request with a json body
+	body := `{"user":"foo","password":"secret","nested":[{"token":"abc","id":1}]}`
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader(body))
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is logged with the redacted fields
+	data := mapFromBuffer(b)
+	a.JSONEq(`{"user":"foo","password":"*****","nested":[{"token":"*****","id":1}]}`, data["request_body"].(string))
+
+	// and: the body can still be read
+	restored, err := ioutil.ReadAll(r.Body)
+	a.NoError(err)
+	a.Equal(body, string(restored))
+}
+
+func Test_Call_BodyCapture_Form(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	CallBodyRedactedFields = []string{"password"}
+	defer func() {
+		CallBodyCapture = false
+		CallBodyRedactedFields = nil
+	}()
+
+	// and a request with a form body
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader("user=foo&Password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is logged with the redacted fields
+	a.Equal("Password=*****&user=foo", mapFromBuffer(b)["request_body"])
+}
+
+func Test_Call_BodyCapture_NotRedactable(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	CallBodyRedactedFields = []string{"password"}
+	defer func() {
+		CallBodyCapture = false
+		CallBodyRedactedFields = nil
+	}()
+
+	// and a request with a body, which can not be redacted
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader("<login><password>secret</password></login>"))
+	r.Header.Set("Content-Type", "application/xml")
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is replaced by a marker
+	a.Equal("(not redactable)", mapFromBuffer(b)["request_body"])
+	a.NotContains(b.String(), "secret")
+}
+
+func Test_Call_BodyCapture_MaxSize(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture with a small size
+	CallBodyCapture = true
+	CallBodyMaxSize = 10
+	defer func() {
+		CallBodyCapture = false
+		CallBodyMaxSize = 4096
+	}()
+
+	// and a request with a large body
+	body := strings.Repeat("x", 100)
+	r, _ := http.NewRequest("POST", "http://www.example.org/upload", strings.NewReader(body))
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is not logged
+	a.Equal("(exceeds 10 bytes)", mapFromBuffer(b)["request_body"])
+
+	// and: the complete body can still be read
+	restored, err := ioutil.ReadAll(r.Body)
+	a.NoError(err)
+	a.Equal(body, string(restored))
+}
+
+func Test_Call_BodyCapture_Disabled(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: a call with a body is logged
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader(`{"password":"secret"}`))
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is not logged
+	a.NotContains(mapFromBuffer(b), "request_body")
+}
+
+func Test_Call_BodyCapture_GetBody(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	defer func() { CallBodyCapture = false }()
+
+	// and a request whose body was already sent
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader(`{"user":"foo"}`))
+	ioutil.ReadAll(r.Body)
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is logged from the copy
+	a.Equal(`{"user":"foo"}`, mapFromBuffer(b)["request_body"])
+}
+
+func Test_Call_BodyCapture_WithoutGetBody(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	defer func() { CallBodyCapture = false }()
+
+	// and a server, which reads the body
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer server.Close()
+
+	// and a request with a plain reader as body, which provides no GetBody
+	body := `{"user":"foo"}`
+	r, _ := http.NewRequest("POST", server.URL+"/login", struct{ io.Reader }{strings.NewReader(body)})
+	a.Nil(r.GetBody)
+
+	// when: the request is sent with the round tripper
+	client := &http.Client{Transport: NewLoggingRoundTripper(nil)}
+	resp, err := client.Do(r)
+	a.NoError(err)
+	resp.Body.Close()
+
+	// then: the body is not captured
+	a.Equal("(not captured)", mapFromBuffer(b)["request_body"])
+
+	// and: the complete body was sent
+	a.Equal(body, received)
+}
+
+func Test_Call_BodyCapture_NotReadable(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled body capture
+	CallBodyCapture = true
+	defer func() { CallBodyCapture = false }()
+
+	// and a request whose copy of the body can not be read
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", strings.NewReader(`{"user":"foo"}`))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return nil, errors.New("body gone")
+	}
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the body is replaced by a marker, which differs from non redactable bodies
+	a.Equal("(not readable)", mapFromBuffer(b)["request_body"])
+}
@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CallErrorLogInterval is the interval in which identical call errors
+// (same error message and endpoint) are logged only once.
+// If errors were suppressed, the last one is logged with their number as suppressed_count when the interval ends.
+// A value <= 0 disables the deduplication.
+var CallErrorLogInterval time.Duration
+
+// maximum number of tracked errors, if it is reached expired errors are removed
+// and then the oldest error is evicted after its suppressed errors were summarized
+const callErrorLimiterMaxEntries = 1000
+
+var callErrors = &callErrorLimiter{}
+
+type callErrorLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*callErrorEntry
+}
+
+type callErrorEntry struct {
+	lastLogged time.Time
+	suppressed int
+	// the log entry and error of the last suppressed error, which are logged as summary at the end of the interval
+	last      *logrus.Entry
+	lastError error
+	scheduled bool
+}
+
+// allow reports whether the error with the given key should be logged
+// and how many identical errors were suppressed since the last logged one.
+// Suppressed errors are logged as summary by flush at the end of the interval.
+func (l *callErrorLimiter) allow(key string, now time.Time, interval time.Duration, e *logrus.Entry, err error) (bool, int) {
+	l.mu.Lock()
+
+	if l.entries == nil {
+		l.entries = map[string]*callErrorEntry{}
+	}
+
+	entry, exists := l.entries[key]
+	if exists && now.Sub(entry.lastLogged) < interval {
+		entry.suppressed++
+		entry.last, entry.lastError = e, err
+		if !entry.scheduled {
+			entry.scheduled = true
+			time.AfterFunc(entry.lastLogged.Add(interval).Sub(now), func() {
+				l.flush(key, entry)
+			})
+		}
+		l.mu.Unlock()
+		return false, 0
+	}
+
+	var evicted *callErrorEntry
+	if !exists {
+		if len(l.entries) >= callErrorLimiterMaxEntries {
+			l.removeExpired(now, interval)
+		}
+		if len(l.entries) >= callErrorLimiterMaxEntries {
+			evicted = l.evictOldest()
+		}
+		entry = &callErrorEntry{}
+		l.entries[key] = entry
+	}
+
+	suppressed := entry.suppressed
+	entry.lastLogged = now
+	entry.suppressed = 0
+	entry.last, entry.lastError = nil, nil
+	l.mu.Unlock()
+
+	if evicted != nil && evicted.suppressed > 0 {
+		// the pending summary of the evicted error is not logged by flush anymore
+		evicted.last.WithField("suppressed_count", evicted.suppressed).Error(evicted.lastError)
+	}
+	return true, suppressed
+}
+
+// flush logs the last suppressed error with the number of suppressed errors and starts a new interval,
+// so that errors which keep occurring are summarized periodically
+func (l *callErrorLimiter) flush(key string, entry *callErrorEntry) {
+	l.mu.Lock()
+	if l.entries[key] != entry {
+		// evicted in the meantime, the summary was logged on eviction
+		l.mu.Unlock()
+		return
+	}
+	entry.scheduled = false
+	if entry.suppressed == 0 {
+		// already logged with an allowed error
+		l.mu.Unlock()
+		return
+	}
+	e, err, suppressed := entry.last, entry.lastError, entry.suppressed
+	entry.lastLogged = Now()
+	entry.suppressed = 0
+	entry.last, entry.lastError = nil, nil
+	l.mu.Unlock()
+
+	e.WithField("suppressed_count", suppressed).Error(err)
+}
+
+// removeExpired removes the errors whose interval has passed.
+// Errors with a pending summary are kept until the summary was logged by flush.
+func (l *callErrorLimiter) removeExpired(now time.Time, interval time.Duration) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastLogged) >= interval && !entry.scheduled {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// evictOldest removes the error which was logged least recently and returns it
+func (l *callErrorLimiter) evictOldest() *callErrorEntry {
+	var oldestKey string
+	var oldest *callErrorEntry
+	for key, entry := range l.entries {
+		if oldest == nil || entry.lastLogged.Before(oldest.lastLogged) {
+			oldestKey, oldest = key, entry
+		}
+	}
+	delete(l.entries, oldestKey)
+	return oldest
+}
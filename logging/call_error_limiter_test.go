@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Call_ErrorDeduplication(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an enabled deduplication
+	CallErrorLogInterval = time.Hour
+	defer func() {
+		CallErrorLogInterval = 0
+		callErrors = &callErrorLimiter{}
+	}()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when the same error occurs three times
+	for i := 0; i < 3; i++ {
+		Call(r, nil, time.Now(), errors.New("connection refused"))
+	}
+
+	// then: it is logged only once
+	a.Equal(1, strings.Count(b.String(), "\n"))
+
+	// when a different endpoint fails
+	other, _ := http.NewRequest("GET", "http://www.example.org/bar", nil)
+	Call(other, nil, time.Now(), errors.New("connection refused"))
+
+	// then: it is logged
+	a.Equal(2, strings.Count(b.String(), "\n"))
+
+	// when the interval has passed
+	b.Reset()
+	for _, entry := range callErrors.entries {
+		entry.lastLogged = time.Now().Add(-2 * time.Hour)
+	}
+	Call(r, nil, time.Now(), errors.New("connection refused"))
+
+	// then: the suppressed count is logged
+	data := mapFromBuffer(b)
+	a.Equal("connection refused", data["error"])
+	a.Equal(2.0, data["suppressed_count"])
+}
+
+func Test_Call_ErrorDeduplication_Summary(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := &syncBuffer{}
+	SetOutput(b)
+
+	// and an enabled deduplication with a short interval
+	CallErrorLogInterval = 50 * time.Millisecond
+	defer func() {
+		CallErrorLogInterval = 0
+		callErrors = &callErrorLimiter{}
+	}()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when the same error occurs three times and the outage ends
+	for i := 0; i < 3; i++ {
+		Call(r, nil, time.Now(), errors.New("connection refused"))
+	}
+
+	// then: the suppressed errors are summarized at the end of the interval
+	a.Eventually(func() bool { return strings.Count(b.String(), "\n") == 2 }, time.Second, 10*time.Millisecond)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	data := mapFromBuffer(bytes.NewBufferString(lines[1]))
+	a.Equal("connection refused", data["error"])
+	a.Equal(2.0, data["suppressed_count"])
+
+	// and: no further summary is logged without further errors
+	time.Sleep(100 * time.Millisecond)
+	a.Equal(2, strings.Count(b.String(), "\n"))
+}
+
+func Test_CallErrorLimiter_RemoveExpired(t *testing.T) {
+	a := assert.New(t)
+
+	l := &callErrorLimiter{}
+	now := time.Now()
+	l.allow("old", now.Add(-2*time.Hour), time.Hour, Logger, nil)
+	l.allow("new", now, time.Hour, Logger, nil)
+
+	l.removeExpired(now, time.Hour)
+
+	a.NotContains(l.entries, "old")
+	a.Contains(l.entries, "new")
+}
+
+func Test_CallErrorLimiter_RemoveExpired_PendingSummary(t *testing.T) {
+	a := assert.New(t)
+
+	// given an expired error with a suppressed error, whose summary is pending
+	l := &callErrorLimiter{}
+	now := time.Now()
+	l.allow("old", now.Add(-2*time.Hour), time.Hour, Logger, nil)
+	l.allow("old", now.Add(-2*time.Hour), time.Hour, Logger, errors.New("connection refused"))
+
+	// when: the expired errors are removed
+	l.removeExpired(now, time.Hour)
+
+	// then: the error is kept until its summary was logged
+	a.Contains(l.entries, "old")
+}
+
+func Test_CallErrorLimiter_EvictOldest(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a limiter, whose oldest error has a suppressed error
+	l := &callErrorLimiter{}
+	now := time.Now()
+	l.allow("oldest", now.Add(-time.Minute), time.Hour, Logger, nil)
+	l.allow("oldest", now.Add(-time.Minute), time.Hour, Logger, errors.New("connection refused"))
+
+	// and which is full with errors, which are not expired
+	for i := 1; i < callErrorLimiterMaxEntries; i++ {
+		l.allow(fmt.Sprintf("key-%d", i), now, time.Hour, Logger, nil)
+	}
+	a.Equal("", b.String())
+
+	// when: another error occurs
+	allowed, _ := l.allow("new", now, time.Hour, Logger, errors.New("timeout"))
+
+	// then: it is logged
+	a.True(allowed)
+	a.Contains(l.entries, "new")
+
+	// and: the oldest error is evicted
+	a.Len(l.entries, callErrorLimiterMaxEntries)
+	a.NotContains(l.entries, "oldest")
+
+	// and: its suppressed error is summarized
+	data := mapFromBuffer(b)
+	a.Equal("connection refused", data["message"])
+	a.Equal(1.0, data["suppressed_count"])
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"context"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+type callTraceKey struct{}
+
+// CallTrace records how the connection of an outgoing call was obtained,
+// so that it is logged by the subsequent Call as conn_reused, conn_was_idle and conn_wait.
+type CallTrace struct {
+	mu    sync.Mutex
+	start time.Time
+	conn  *ConnInfo
+}
+
+// ConnInfo describes the connection of an outgoing call.
+type ConnInfo struct {
+	Reused  bool
+	WasIdle bool
+	Wait    time.Duration
+}
+
+// NewCallTrace creates a CallTrace for a call started at the given time.
+func NewCallTrace(start time.Time) *CallTrace {
+	return &CallTrace{start: start}
+}
+
+// ClientTrace returns the hooks which record the connection information.
+func (ct *CallTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.mu.Lock()
+			defer ct.mu.Unlock()
+			ct.conn = &ConnInfo{
+				Reused:  info.Reused,
+				WasIdle: info.WasIdle,
+				Wait:    Now().Sub(ct.start),
+			}
+		},
+	}
+}
+
+// WithContext returns a context carrying the client trace and the CallTrace itself,
+// so that requests with this context are traced and the result is logged by Call.
+func (ct *CallTrace) WithContext(ctx context.Context) context.Context {
+	ctx = httptrace.WithClientTrace(ctx, ct.ClientTrace())
+	return context.WithValue(ctx, callTraceKey{}, ct)
+}
+
+// getConnInfo returns the connection information recorded for the call or nil, if none was recorded
+func getConnInfo(ctx context.Context) *ConnInfo {
+	ct, ok := ctx.Value(callTraceKey{}).(*CallTrace)
+	if !ok {
+		return nil
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.conn
+}
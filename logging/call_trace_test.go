@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CallTrace(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a fixed clock
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	Now = func() time.Time { return start.Add(20 * time.Millisecond) }
+	defer func() { Now = time.Now }()
+
+	// and a traced request, which got a reused connection
+	ct := NewCallTrace(start)
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r = r.WithContext(ct.WithContext(r.Context()))
+	httptrace.ContextClientTrace(r.Context()).GotConn(httptrace.GotConnInfo{Reused: true, WasIdle: true})
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, start, nil)
+
+	// then: the connection information is logged
+	data := mapFromBuffer(b)
+	a.Equal(true, data["conn_reused"])
+	a.Equal(true, data["conn_was_idle"])
+	a.Equal(20.0, data["conn_wait"])
+}
+
+func Test_CallTrace_NoConn(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a traced request, which failed before getting a connection
+	ct := NewCallTrace(time.Now())
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r = r.WithContext(ct.WithContext(r.Context()))
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: no connection information is logged
+	a.NotContains(mapFromBuffer(b), "conn_reused")
+}
+
+func ExampleNewCallTrace() {
+	start := time.Now()
+	ct := NewCallTrace(start)
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r = r.WithContext(ct.WithContext(r.Context()))
+
+	resp, err := http.DefaultClient.Do(r)
+	Call(r, resp, start, err)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
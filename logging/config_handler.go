@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// config is the serialized configuration of the package, as returned by the ConfigHandler
+type config struct {
+	Level                         string            `json:"level"`
+	TextLogging                   bool              `json:"text_logging"`
+	AnonymizedQueryParams         []string          `json:"anonymized_query_params"`
+	AnonymizedQueryParamPatterns  []string          `json:"anonymized_query_param_patterns"`
+	AnonymizedPathSegmentRules    int               `json:"anonymized_path_segment_rules"`
+	AccessLogWithCookies          bool              `json:"access_log_with_cookies"`
+	AccessLogCookiesOnlyOnFailure bool              `json:"access_log_cookies_only_on_failure"`
+	AccessLogCookiesBlacklist     []string          `json:"access_log_cookies_blacklist"`
+	AccessLogCookiesWhitelist     []string          `json:"access_log_cookies_whitelist"`
+	MaskedCookies                 []string          `json:"masked_cookies"`
+	LogRequestHeaders             []string          `json:"log_request_headers"`
+	RedactedHeaders               []string          `json:"redacted_headers"`
+	CorrelationIdHeader           string            `json:"correlation_id_header"`
+	CorrelationIdHeaderAliases    []string          `json:"correlation_id_header_aliases"`
+	UserCorrelationIdHeader       string            `json:"user_correlation_id_header"`
+	CallIdHeader                  string            `json:"call_id_header"`
+	PropagatedHeaders             map[string]string `json:"propagated_headers"`
+	TrustedProxyCount             int               `json:"trusted_proxy_count"`
+	MaxLoggedUrlLength            int               `json:"max_logged_url_length"`
+	MaxLoggedQueryParams          int               `json:"max_logged_query_params"`
+	CallBodyCapture               bool              `json:"call_body_capture"`
+	CallBodyRedactedFields        []string          `json:"call_body_redacted_fields"`
+}
+
+// levelUpdate is the body of requests changing the level with the ConfigHandler
+type levelUpdate struct {
+	Level string `json:"level"`
+}
+
+// ConfigHandler returns a handler, which responds with the current configuration of the package as json,
+// e.g. the level and the anonymized query params. It should only be exposed to operators, e.g. on an admin port.
+// A POST with a body like {"level":"debug"} changes the level of the Logger and responds with the new level.
+func ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(currentConfig())
+		case http.MethodPost:
+			updateLevel(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// updateLevel changes the level of the Logger, keeping its output, format and hooks
+func updateLevel(w http.ResponseWriter, r *http.Request) {
+	var update levelUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	lvl, err := logrus.ParseLevel(update.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l := GetLogger().Logger
+	l.SetLevel(lvl)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(levelUpdate{Level: l.GetLevel().String()})
+}
+
+func currentConfig() config {
+	l := GetLogger().Logger
+
+	patterns := make([]string, 0, len(AnonymizedQueryParamPatterns))
+	for _, p := range AnonymizedQueryParamPatterns {
+		patterns = append(patterns, p.String())
+	}
+
+	return config{
+		Level:                         l.GetLevel().String(),
+		TextLogging:                   isTextLogging(currentFormatter()),
+		AnonymizedQueryParams:         anonymizedQueryParams(),
+		AnonymizedQueryParamPatterns:  patterns,
+		AnonymizedPathSegmentRules:    len(AnonymizedPathSegments),
+		AccessLogWithCookies:          AccessLogWithCookies,
+		AccessLogCookiesOnlyOnFailure: AccessLogCookiesOnlyOnFailure,
+		AccessLogCookiesBlacklist:     accessLogCookiesBlacklist(),
+		AccessLogCookiesWhitelist:     AccessLogCookiesWhitelist,
+		MaskedCookies:                 MaskedCookies,
+		LogRequestHeaders:             LogRequestHeaders,
+		RedactedHeaders:               RedactedHeaders,
+		CorrelationIdHeader:           CorrelationIdHeader,
+		CorrelationIdHeaderAliases:    CorrelationIdHeaderAliases,
+		UserCorrelationIdHeader:       UserCorrelationIdHeader,
+		CallIdHeader:                  CallIdHeader,
+		PropagatedHeaders:             PropagatedHeaders,
+		TrustedProxyCount:             TrustedProxyCount,
+		MaxLoggedUrlLength:            MaxLoggedUrlLength,
+		MaxLoggedQueryParams:          MaxLoggedQueryParams,
+		CallBodyCapture:               CallBodyCapture,
+		CallBodyRedactedFields:        CallBodyRedactedFields,
+	}
+}
+
+// isTextLogging returns true, if the entries are formatted as text by default
+func isTextLogging(formatter logrus.Formatter) bool {
+	if tf, ok := formatter.(*typeFormatter); ok {
+		formatter = tf.defaultFormatter
+	}
+	_, textLogging := formatter.(*logrus.TextFormatter)
+	return textLogging
+}
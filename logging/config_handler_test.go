@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ConfigHandler(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a configuration
+	Set("warn", false)
+	defer Set("info", false)
+	SetAnonymizedQueryParams([]string{"password", "token"})
+	defer SetAnonymizedQueryParams(nil)
+	AnonymizedQueryParamPatterns = []*regexp.Regexp{regexp.MustCompile(`^secret_`)}
+	defer func() { AnonymizedQueryParamPatterns = nil }()
+	SetAccessLogCookiesBlacklist([]string{"session"})
+	defer SetAccessLogCookiesBlacklist(nil)
+
+	// when: the handler is requested
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://www.example.org/config", nil)
+	ConfigHandler().ServeHTTP(w, r)
+
+	// then: the configuration is returned as json
+	a.Equal(200, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	data := map[string]interface{}{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &data))
+	a.Equal("warning", data["level"])
+	a.Equal(false, data["text_logging"])
+	a.Equal([]interface{}{"password", "token"}, data["anonymized_query_params"])
+	a.Equal([]interface{}{"^secret_"}, data["anonymized_query_param_patterns"])
+	a.Equal([]interface{}{"session"}, data["access_log_cookies_blacklist"])
+	a.Equal("X-Correlation-Id", data["correlation_id_header"])
+}
+
+func Test_ConfigHandler_MethodNotAllowed(t *testing.T) {
+	a := assert.New(t)
+
+	// when: the handler is requested with a method other than GET or POST
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("PUT", "http://www.example.org/config", nil)
+	ConfigHandler().ServeHTTP(w, r)
+
+	// then: the request is rejected
+	a.Equal(http.StatusMethodNotAllowed, w.Code)
+	a.Equal("GET, HEAD, POST", w.Header().Get("Allow"))
+}
+
+func Test_ConfigHandler_SetLevel(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a text logger writing to a buffer with a hook
+	b := bytes.NewBuffer(nil)
+	a.NoError(SetWithOutput("info", true, b))
+	defer Set("info", false)
+	hook := &levelCountingHook{}
+	GetLogger().Logger.AddHook(hook)
+
+	// when: the level is changed to debug
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://www.example.org/config", strings.NewReader(`{"level":"debug"}`))
+	ConfigHandler().ServeHTTP(w, r)
+
+	// then: the new level is returned
+	a.Equal(200, w.Code)
+	a.JSONEq(`{"level":"debug"}`, w.Body.String())
+
+	// and: debug logs are written to the same output in the same format and passed to the hook
+	GetLogger().Debug("hello")
+	a.Contains(b.String(), "level=debug message=hello")
+	a.Equal(1, hook.count)
+}
+
+func Test_ConfigHandler_SetLevel_KeepsFormatter(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a text logger with the type formatter
+	b := bytes.NewBuffer(nil)
+	a.NoError(SetWithOutput("info", true, b))
+	defer Set("info", false)
+	SetTypeFormatter("access", &logrus.JSONFormatter{})
+	formatter := currentFormatter()
+
+	// when: the level is changed to debug
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://www.example.org/config", strings.NewReader(`{"level":"debug"}`))
+	ConfigHandler().ServeHTTP(w, r)
+
+	// then: the formatter is kept
+	a.Equal(200, w.Code)
+	a.True(formatter == currentFormatter())
+
+	// and: the config still reports text logging
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://www.example.org/config", nil)
+	ConfigHandler().ServeHTTP(w, r)
+	data := map[string]interface{}{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &data))
+	a.Equal(true, data["text_logging"])
+	a.Equal("debug", data["level"])
+}
+
+// levelCountingHook counts the entries it is fired for
+type levelCountingHook struct {
+	count int
+}
+
+func (h *levelCountingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *levelCountingHook) Fire(*logrus.Entry) error {
+	h.count++
+	return nil
+}
+
+func Test_ConfigHandler_SetInvalidLevel(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger with level warn
+	a.NoError(Set("warn", false))
+	defer Set("info", false)
+
+	for _, body := range []string{`{"level":"verbose"}`, `{}`, `level=debug`} {
+		// when: an invalid level is posted
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://www.example.org/config", strings.NewReader(body))
+		ConfigHandler().ServeHTTP(w, r)
+
+		// then: the request is rejected
+		a.Equal(http.StatusBadRequest, w.Code, body)
+
+		// and: the level is unchanged
+		a.Equal(logrus.WarnLevel, GetLogger().Logger.GetLevel())
+	}
+}
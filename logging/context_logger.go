@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LoggerFromContext returns the entry for application logs of the request, which is prepared by the LogMiddleware
+// WithContextLogger. It contains the route and the fields added with AddLogField at the time of the call.
+// Without a prepared entry, an entry like Application with the correlation ids of the context is returned.
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	lc := getLogContext(ctx)
+	var entry *logrus.Entry
+	if lc != nil {
+		lc.mu.Lock()
+		entry = lc.entry
+		lc.mu.Unlock()
+	}
+	if entry == nil {
+		fields := logrus.Fields{
+			"type": "application",
+		}
+		setRecordCorrelationIds(fields, CorrelationIdFromContext(ctx), UserCorrelationIdFromContext(ctx))
+		traceId, spanId := getTraceIds(ctx)
+		setTraceIds(fields, traceId, spanId)
+		return GetLogger().WithFields(fields)
+	}
+
+	fields := logrus.Fields{}
+	if route := getRoute(ctx); route != "" {
+		fields["route"] = route
+	}
+	// the custom fields can not overwrite the fields of the entry
+	for k, v := range lc.customFields() {
+		if _, exists := entry.Data[k]; !exists {
+			fields[k] = v
+		}
+	}
+	return entry.WithFields(fields)
+}
+
+// contextEntry prepares the entry for application logs of the request, which is returned by LoggerFromContext
+func (mw *LogMiddleware) contextEntry(r *http.Request, lc *logContext) *logrus.Entry {
+	fields := logrus.Fields{
+		"type": "application",
+	}
+	setRecordCorrelationIds(fields, lc.correlationId, GetUserCorrelationId(r.Header))
+	setPropagatedIds(fields, getPropagatedIds(r.Header))
+	traceId, spanId := getTraceIds(r.Context())
+	setTraceIds(fields, traceId, spanId)
+	if lc.requestId != "" {
+		fields["request_id"] = lc.requestId
+	}
+	if mw.fieldEnricher != nil {
+		// the enriched fields can not overwrite the fields of the entry
+		for k, v := range mw.fieldEnricher(r) {
+			if _, exists := fields[k]; !exists {
+				fields[k] = v
+			}
+		}
+	}
+	return GetLogger().WithFields(fields)
+}
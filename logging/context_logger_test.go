@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoggerFromContext(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware with a context logger
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(ContextWithRoute(r.Context(), "/users/{id}"))
+		AddLogField(r, "tenant", "acme")
+		AddLogField(r, "type", "ignored")
+
+		// when: the handler logs through the entry of the context
+		LoggerFromContext(r.Context()).Info("hello")
+	}), WithContextLogger())
+	r, _ := http.NewRequest("GET", "http://www.example.org/users/123", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	r.Header.Set(UserCorrelationIdHeader, "user-correlation-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the application log carries the ids, the route and the custom fields of the request
+	data := map[string]interface{}{}
+	records := bytes.Split(bytes.TrimSpace(b.Bytes()), []byte("\n"))
+	a.Len(records, 2)
+	a.NoError(json.Unmarshal(records[0], &data))
+	a.Equal("hello", data["message"])
+	a.Equal("application", data["type"])
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("user-correlation-123", data["user_correlation_id"])
+	a.Equal("/users/{id}", data["route"])
+	a.Equal("acme", data["tenant"])
+}
+
+func Test_LoggerFromContext_FieldEnricher(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware with a context logger and a field enricher
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// when: the handler logs through the entry of the context
+		LoggerFromContext(r.Context()).Info("hello")
+	}), WithContextLogger(), WithFieldEnricher(func(r *http.Request) logrus.Fields {
+		return logrus.Fields{"tenant": r.Header.Get("X-Tenant"), "type": "ignored", "correlation_id": "ignored"}
+	}))
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	r.Header.Set("X-Tenant", "acme")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the application log carries the enriched fields
+	data := map[string]interface{}{}
+	records := bytes.Split(bytes.TrimSpace(b.Bytes()), []byte("\n"))
+	a.Len(records, 2)
+	a.NoError(json.Unmarshal(records[0], &data))
+	a.Equal("hello", data["message"])
+	a.Equal("acme", data["tenant"])
+
+	// and: the enriched fields do not overwrite the fields of the entry
+	a.Equal("application", data["type"])
+	a.Equal("correlation-123", data["correlation_id"])
+}
+
+func Test_LoggerFromContext_WithoutMiddleware(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: logging through the entry of a context with a correlation id
+	ctx := ContextWithCorrelationId(context.Background(), "correlation-123")
+	LoggerFromContext(ctx).Info("hello")
+
+	// then: the correlation id of the context is logged
+	data := logRecordFromBuffer(b)
+	a.Equal("application", data.Type)
+	a.Equal("correlation-123", data.CorrelationId)
+	a.Equal("", data.UserCorrelationId)
+}
@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"context"
 	"math/rand"
 	"net/http"
 	"time"
@@ -14,20 +15,123 @@ func init() {
 
 var CorrelationIdHeader = "X-Correlation-Id"
 
+// Further headers carrying the correlation id, e.g. X-Request-Id, if the CorrelationIdHeader is not set.
+// They are checked in order and the first non-empty value is used.
+var CorrelationIdHeaderAliases []string
+
+// Mapping from the names of further propagated headers (e.g. X-Tenant-Id) to the names of their log fields.
+// They are logged like the correlation ids in access, call and application logs.
+// Field names which collide with the fields of an entry, e.g. type or correlation_id, are skipped.
+var PropagatedHeaders = map[string]string{}
+
+// CallIdHeader holds the id shared by all attempts of a retried outgoing call
+var CallIdHeader = "X-Call-Id"
+
+// CorrelationIdGenerator generates the correlation id for requests without one.
+// It defaults to a random string of 10 letters and digits.
+var CorrelationIdGenerator = defaultCorrelationIdGenerator
+
 // EnsureCorrelationId returns the correlation from of the request.
 // If the request does not have a correlation id, one will be generated and set to the request.
+// It is idempotent, an existing id in the header or the context of the request is never replaced,
+// so that nested LogMiddlewares log the same correlation id.
 func EnsureCorrelationId(r *http.Request) string {
-	id := r.Header.Get(CorrelationIdHeader)
-	if id == "" {
-		id = randStringBytes(10)
-		r.Header.Set(CorrelationIdHeader, id)
+	id, _ := ensureCorrelationId(r, CorrelationIdHeader)
+	return id
+}
+
+// ensureCorrelationId returns the correlation id of the request like getRequestCorrelationId
+// and whether it was generated, because the request did not have one.
+// An id of an alias or the context is set to the given header.
+func ensureCorrelationId(r *http.Request, header string) (string, bool) {
+	if id := getRequestCorrelationId(r, header); id != "" {
+		r.Header.Set(header, id)
+		return id, false
+	}
+	return ensureHeaderId(r, header)
+}
+
+// getRequestCorrelationId returns the correlation id in the given header, the CorrelationIdHeaderAliases
+// or the context of the request, e.g. set by an outer middleware with another correlation header.
+// It returns an empty string, if the request does not have one.
+func getRequestCorrelationId(r *http.Request, header string) string {
+	if id := r.Header.Get(header); id != "" {
+		return id
+	}
+	if id := getAliasedCorrelationId(r.Header); id != "" {
+		return id
+	}
+	return CorrelationIdFromContext(r.Context())
+}
+
+// ensureHeaderId returns the id in the given header of the request and whether it was generated,
+// because the request did not have one.
+func ensureHeaderId(r *http.Request, header string) (string, bool) {
+	id := r.Header.Get(header)
+	if id != "" {
+		return id, false
 	}
+	id = CorrelationIdGenerator()
+	r.Header.Set(header, id)
+	return id, true
+}
+
+// EnsureCallId returns the call id of the outgoing request in the CallIdHeader.
+// If the request does not have one, it is generated and set to the request.
+// It has to be called before the first attempt is sent, so that all attempts carry the same call id.
+func EnsureCallId(r *http.Request) string {
+	id, _ := ensureHeaderId(r, CallIdHeader)
 	return id
 }
 
 // GetCorrelationId returns the correlation from of the request.
+// If the CorrelationIdHeader is not set, the CorrelationIdHeaderAliases are checked in order.
 func GetCorrelationId(h http.Header) string {
-	return h.Get(CorrelationIdHeader)
+	if id := h.Get(CorrelationIdHeader); id != "" {
+		return id
+	}
+	return getAliasedCorrelationId(h)
+}
+
+func getAliasedCorrelationId(h http.Header) string {
+	for _, alias := range CorrelationIdHeaderAliases {
+		if id := h.Get(alias); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// getPropagatedIds returns the values of the PropagatedHeaders by their field names or nil, if none is set
+func getPropagatedIds(h http.Header) map[string]string {
+	var ids map[string]string
+	for header, field := range PropagatedHeaders {
+		if value := h.Get(header); value != "" {
+			if ids == nil {
+				ids = map[string]string{}
+			}
+			ids[field] = value
+		}
+	}
+	return ids
+}
+
+type correlationIdKey struct{}
+
+// ContextWithCorrelationId returns a context carrying the correlation id, e.g. to propagate it to outgoing calls.
+// The LogMiddleware adds the correlation id of the request to its context.
+func ContextWithCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, id)
+}
+
+// CorrelationIdFromContext returns the correlation id of the context or an empty string, if none is set.
+func CorrelationIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIdKey{}).(string)
+	return id
+}
+
+func defaultCorrelationIdGenerator() string {
+	return randStringBytes(10)
 }
 
 func randStringBytes(n int) string {
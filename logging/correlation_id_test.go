@@ -0,0 +1,185 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EnsureCorrelationId(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a request without correlation id
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: the correlation id is ensured
+	id := EnsureCorrelationId(r)
+
+	// then: a random id is generated and set
+	a.Len(id, 10)
+	a.Equal(id, GetCorrelationId(r.Header))
+
+	// and: an existing id is kept
+	a.Equal(id, EnsureCorrelationId(r))
+}
+
+func Test_EnsureCorrelationId_Generator(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a deterministic generator
+	CorrelationIdGenerator = func() string {
+		return "dc1-01ARZ3NDEKTSV4RRFFQ69G5FAV"
+	}
+	defer func() { CorrelationIdGenerator = defaultCorrelationIdGenerator }()
+
+	// when: the correlation id is ensured
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	id := EnsureCorrelationId(r)
+
+	// then: the generated id is used
+	a.Equal("dc1-01ARZ3NDEKTSV4RRFFQ69G5FAV", id)
+	a.Equal("dc1-01ARZ3NDEKTSV4RRFFQ69G5FAV", GetCorrelationId(r.Header))
+}
+
+func Test_GetCorrelationId_Aliases(t *testing.T) {
+	a := assert.New(t)
+
+	// given: aliases for the correlation id header
+	CorrelationIdHeaderAliases = []string{"X-Request-ID", "X-Trace-Id"}
+	defer func() { CorrelationIdHeaderAliases = nil }()
+
+	// when: the id arrives under an alias
+	h := http.Header{}
+	h.Set("x-trace-id", "trace-123")
+	h.Set("X-Request-ID", "request-123")
+
+	// then: the first set alias is used
+	a.Equal("request-123", GetCorrelationId(h))
+
+	// and: the correlation id header takes precedence
+	h.Set(CorrelationIdHeader, "correlation-123")
+	a.Equal("correlation-123", GetCorrelationId(h))
+
+	// when: the correlation id of a request with an alias is ensured
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("X-Trace-Id", "trace-123")
+	id := EnsureCorrelationId(r)
+
+	// then: the id of the alias is kept and set to the correlation id header
+	a.Equal("trace-123", id)
+	a.Equal("trace-123", r.Header.Get(CorrelationIdHeader))
+}
+
+func Test_CorrelationIdFromContext(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a context without correlation id
+	ctx := context.Background()
+
+	// then: an empty id is returned
+	a.Equal("", CorrelationIdFromContext(ctx))
+
+	// and: the id of a context with correlation id is returned
+	a.Equal("correlation-123", CorrelationIdFromContext(ContextWithCorrelationId(ctx, "correlation-123")))
+}
+
+func Test_PropagatedHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and two propagated headers
+	PropagatedHeaders = map[string]string{"X-Tenant-Id": "tenant_id", "X-Session-Id": "session_id"}
+	defer func() { PropagatedHeaders = map[string]string{} }()
+
+	// and a request with them
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("X-Tenant-Id", "tenant-1")
+	r.Header.Set("X-Session-Id", "session-1")
+
+	// when: the access is logged
+	Access(r, time.Now(), 200)
+
+	// then: the ids are logged as fields
+	data := mapFromBuffer(b)
+	a.Equal("tenant-1", data["tenant_id"])
+	a.Equal("session-1", data["session_id"])
+
+	// when: an application log is written
+	b.Reset()
+	Application(r.Header).Info("hello")
+
+	// then: they are logged as well
+	data = mapFromBuffer(b)
+	a.Equal("tenant-1", data["tenant_id"])
+	a.Equal("session-1", data["session_id"])
+
+	// and: headers which are not set are omitted
+	r.Header.Del("X-Session-Id")
+	a.Equal(map[string]string{"tenant_id": "tenant-1"}, getPropagatedIds(r.Header))
+}
+
+func Test_PropagatedHeaders_CollidingFields(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and propagated headers colliding with built-in fields
+	PropagatedHeaders = map[string]string{
+		"X-Correlation": "correlation_id",
+		"X-Type":        "type",
+		"X-Url":         "url",
+		"X-Version":     "@version",
+		"X-Tenant-Id":   "tenant_id",
+	}
+	defer func() { PropagatedHeaders = map[string]string{} }()
+
+	// and a request with them
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	r.Header.Set("X-Correlation", "spoofed")
+	r.Header.Set("X-Type", "spoofed")
+	r.Header.Set("X-Url", "spoofed")
+	r.Header.Set("X-Version", "spoofed")
+	r.Header.Set("X-Tenant-Id", "tenant-1")
+
+	// when: the access is logged
+	Access(r, time.Now(), 200)
+
+	// then: the built-in fields are kept
+	data := mapFromBuffer(b)
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("access", data["type"])
+	a.Equal("/foo", data["url"])
+	a.NotEqual("spoofed", data["@version"])
+	a.Equal("tenant-1", data["tenant_id"])
+
+	// when: a call is logged
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the built-in fields are kept as well
+	data = mapFromBuffer(b)
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("call", data["type"])
+	a.Equal("/foo", data["url"])
+	a.Equal("tenant-1", data["tenant_id"])
+
+	// when: an application log is written
+	b.Reset()
+	Application(r.Header).Info("hello")
+
+	// then: the type is kept
+	data = mapFromBuffer(b)
+	a.Equal("application", data["type"])
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("tenant-1", data["tenant_id"])
+}
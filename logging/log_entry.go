@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AccessEntry is the typed contract of the fields of access log entries, as emitted by AccessRecord.Fields.
+// Custom fields, propagated ids and the fields added by the logger, e.g. @timestamp, are not contained.
+type AccessEntry struct {
+	Type                   string            `json:"type"`
+	RemoteIp               string            `json:"remote_ip"`
+	Host                   string            `json:"host"`
+	Port                   string            `json:"port,omitempty"`
+	URL                    string            `json:"url"`
+	QueryLength            int               `json:"query_length,omitempty"`
+	Route                  string            `json:"route,omitempty"`
+	Handler                string            `json:"handler,omitempty"`
+	Method                 string            `json:"method"`
+	Proto                  string            `json:"proto"`
+	TLSVersion             string            `json:"tls_version,omitempty"`
+	TLSCipher              string            `json:"tls_cipher,omitempty"`
+	Duration               int64             `json:"duration"`
+	DurationMicros         int64             `json:"duration_us,omitempty"`
+	DeadlineMs             *int64            `json:"deadline_ms,omitempty"`
+	UserAgent              string            `json:"User_Agent"`
+	RequestSize            *int64            `json:"request_size,omitempty"`
+	ResponseStatus         int               `json:"response_status,omitempty"`
+	ResponseStatusText     string            `json:"response_status_text,omitempty"`
+	ResponseSize           *int              `json:"response_size,omitempty"`
+	Error                  string            `json:"error,omitempty"`
+	Hijacked               bool              `json:"hijacked,omitempty"`
+	ClientDisconnected     bool              `json:"client_disconnected,omitempty"`
+	Slow                   bool              `json:"slow,omitempty"`
+	PanicCategory          string            `json:"panic_category,omitempty"`
+	Stack                  string            `json:"stack,omitempty"`
+	RequestBody            string            `json:"request_body,omitempty"`
+	CorrelationId          string            `json:"correlation_id,omitempty"`
+	CorrelationIdGenerated *bool             `json:"correlation_id_generated,omitempty"`
+	UserCorrelationId      string            `json:"user_correlation_id,omitempty"`
+	RequestId              string            `json:"request_id,omitempty"`
+	TraceId                string            `json:"trace_id,omitempty"`
+	SpanId                 string            `json:"span_id,omitempty"`
+	Cookies                map[string]string `json:"cookies,omitempty"`
+	CookiesOmitted         int               `json:"cookies_omitted,omitempty"`
+	Headers                map[string]string `json:"headers,omitempty"`
+	ResponseHeaders        map[string]string `json:"response_headers,omitempty"`
+	ContentEncoding        string            `json:"content_encoding,omitempty"`
+	ApiVersion             string            `json:"api_version,omitempty"`
+	Baggage                map[string]string `json:"baggage,omitempty"`
+	RateLimit              *int              `json:"rate_limit,omitempty"`
+	RateLimitRemaining     *int              `json:"rate_limit_remaining,omitempty"`
+	RateLimitReset         string            `json:"rate_limit_reset,omitempty"`
+	Throttled              bool              `json:"throttled,omitempty"`
+	RequestCacheControl    string            `json:"request_cache_control,omitempty"`
+	ResponseCacheControl   string            `json:"response_cache_control,omitempty"`
+}
+
+// CallEntry is the typed contract of the fields of call log entries, as emitted by CallRecord.Fields.
+// Propagated ids and the fields added by the logger, e.g. @timestamp, are not contained.
+type CallEntry struct {
+	Type               string `json:"type"`
+	Host               string `json:"host"`
+	URL                string `json:"url"`
+	FullURL            string `json:"full_url"`
+	Method             string `json:"method"`
+	Proto              string `json:"proto,omitempty"`
+	Duration           int64  `json:"duration"`
+	DurationMicros     int64  `json:"duration_us,omitempty"`
+	UserAgent          string `json:"User_Agent,omitempty"`
+	CorrelationId      string `json:"correlation_id,omitempty"`
+	UserCorrelationId  string `json:"user_correlation_id,omitempty"`
+	TraceId            string `json:"trace_id,omitempty"`
+	SpanId             string `json:"span_id,omitempty"`
+	RequestBody        string `json:"request_body,omitempty"`
+	CallId             string `json:"call_id,omitempty"`
+	Attempt            int    `json:"attempt,omitempty"`
+	ConnReused         *bool  `json:"conn_reused,omitempty"`
+	ConnWasIdle        *bool  `json:"conn_was_idle,omitempty"`
+	ConnWait           *int64 `json:"conn_wait,omitempty"`
+	Error              string `json:"error,omitempty"`
+	ResponseStatus     int    `json:"response_status,omitempty"`
+	ResponseStatusText string `json:"response_status_text,omitempty"`
+	ContentType        string `json:"content_type,omitempty"`
+}
+
+// Entry returns the typed fields of the access log entry.
+func (record AccessRecord) Entry() (AccessEntry, error) {
+	var entry AccessEntry
+	err := unmarshalFields(record.Fields(), &entry)
+	return entry, err
+}
+
+// Entry returns the typed fields of the call log entry.
+func (record CallRecord) Entry() (CallEntry, error) {
+	var entry CallEntry
+	err := unmarshalFields(record.Fields(), &entry)
+	return entry, err
+}
+
+// unmarshalFields converts the fields into the entry by their json representation,
+// so that the entry matches the emitted log entries
+func unmarshalFields(fields logrus.Fields, entry interface{}) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, entry)
+}
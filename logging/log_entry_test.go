@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AccessRecord_Entry(t *testing.T) {
+	a := assert.New(t)
+
+	// given: an access record
+	r, _ := http.NewRequest("GET", "http://www.example.org:8080/foo?q=bar", nil)
+	r.Header = http.Header{
+		CorrelationIdHeader: {"correlation-123"},
+		"Cookie":            {"foo=bar"},
+		"User-Agent":        {"agent"},
+	}
+	r.RemoteAddr = "127.0.0.1:1234"
+	record := NewAccessRecord(r, time.Now(), 404, nil)
+	record.ResponseSize = 0
+	record.RateLimit = &RateLimit{Limit: 10, Remaining: 0, Throttled: true}
+
+	// when: the record is converted into the typed entry
+	entry, err := record.Entry()
+
+	// then: the fields match
+	a.NoError(err)
+	a.Equal("access", entry.Type)
+	a.Equal("127.0.0.1", entry.RemoteIp)
+	a.Equal("www.example.org:8080", entry.Host)
+	a.Equal("8080", entry.Port)
+	a.Equal("/foo?q=bar", entry.URL)
+	a.Equal(5, entry.QueryLength)
+	a.Equal("GET", entry.Method)
+	a.Equal("agent", entry.UserAgent)
+	a.Equal(404, entry.ResponseStatus)
+	a.Equal("Not Found", entry.ResponseStatusText)
+	a.Equal(0, *entry.ResponseSize)
+	a.Equal(int64(0), *entry.RequestSize)
+	a.Equal("correlation-123", entry.CorrelationId)
+	a.Nil(entry.CorrelationIdGenerated)
+	a.Equal(map[string]string{"foo": "bar"}, entry.Cookies)
+	a.Equal(10, *entry.RateLimit)
+	a.Equal(0, *entry.RateLimitRemaining)
+	a.True(entry.Throttled)
+	a.Nil(entry.DeadlineMs)
+
+	// and: the entry marshals to the same json as the record
+	recordJson, err := json.Marshal(record.Fields())
+	a.NoError(err)
+	entryJson, err := json.Marshal(entry)
+	a.NoError(err)
+	a.JSONEq(string(recordJson), string(entryJson))
+}
+
+func Test_AccessRecord_Entry_Logged(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: an access entry is logged
+	r, _ := http.NewRequest("POST", "http://www.example.org/foo", nil)
+	Access(r, time.Now(), 201)
+
+	// then: the logged entry matches the typed entry of the record
+	var logged AccessEntry
+	a.NoError(json.Unmarshal(b.Bytes(), &logged))
+	entry, err := NewAccessRecord(r, time.Now(), 201, nil).Entry()
+	a.NoError(err)
+	logged.Duration, entry.Duration = 0, 0
+	a.Equal(entry, logged)
+}
+
+func Test_CallRecord_Entry(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a call record of a failed call
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	record := NewCallRecord(r, nil, time.Now(), errors.New("oops"))
+	record.Conn = &ConnInfo{Reused: false, WasIdle: false, Wait: 2 * time.Millisecond}
+
+	// when: the record is converted into the typed entry
+	entry, err := record.Entry()
+
+	// then: the fields match
+	a.NoError(err)
+	a.Equal("call", entry.Type)
+	a.Equal("www.example.org", entry.Host)
+	a.Equal("/foo", entry.URL)
+	a.Equal("http://www.example.org/foo", entry.FullURL)
+	a.Equal("correlation-123", entry.CorrelationId)
+	a.Equal("oops", entry.Error)
+	a.Equal(0, entry.ResponseStatus)
+	a.False(*entry.ConnReused)
+	a.Equal(int64(2), *entry.ConnWait)
+
+	// and: the entry marshals to the same json as the record
+	recordJson, err := json.Marshal(record.Fields())
+	a.NoError(err)
+	entryJson, err := json.Marshal(entry)
+	a.NoError(err)
+	a.JSONEq(string(recordJson), string(entryJson))
+}
+
+func Test_AccessEntry_MatchesFields(t *testing.T) {
+	a := assert.New(t)
+
+	// given: all optional fields enabled
+	LogDurationMicros = true
+	defer func() { LogDurationMicros = false }()
+
+	// and: an access record with every field set, except the ones with arbitrary keys
+	var record AccessRecord
+	fillFields(t, reflect.ValueOf(&record).Elem(), "CustomFields", "PropagatedIds")
+
+	// then: every field has a matching tag in the AccessEntry
+	tags := jsonTags(AccessEntry{})
+	for key := range record.Fields() {
+		a.Contains(tags, key, "the AccessEntry has no field for %v", key)
+	}
+}
+
+func Test_CallEntry_MatchesFields(t *testing.T) {
+	a := assert.New(t)
+
+	// given: all optional fields enabled
+	LogDurationMicros = true
+	defer func() { LogDurationMicros = false }()
+
+	// and: a call record with every field set, except the ones with arbitrary keys
+	var record CallRecord
+	fillFields(t, reflect.ValueOf(&record).Elem(), "PropagatedIds")
+
+	// then: every field has a matching tag in the CallEntry
+	tags := jsonTags(CallEntry{})
+	for key := range record.Fields() {
+		a.Contains(tags, key, "the CallEntry has no field for %v", key)
+	}
+}
+
+// fillFields sets every field of the struct to a non zero value, so that new fields are covered without changing the tests.
+// It fails for field types, which it can not fill.
+func fillFields(t *testing.T, v reflect.Value, skip ...string) {
+	for i := 0; i < v.NumField(); i++ {
+		if containsFold(skip, v.Type().Field(i).Name) {
+			continue
+		}
+		fillValue(t, v.Type().Field(i).Name, v.Field(i))
+	}
+}
+
+func fillValue(t *testing.T, name string, v reflect.Value) {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Time{}):
+		v.Set(reflect.ValueOf(time.Now()))
+	case v.Type() == reflect.TypeOf((*error)(nil)).Elem():
+		v.Set(reflect.ValueOf(errors.New("oops")))
+	case v.Kind() == reflect.String:
+		v.SetString("foo")
+	case v.Kind() == reflect.Bool:
+		v.SetBool(true)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		v.SetInt(500)
+	case v.Kind() == reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(t, name, v.Elem())
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		v.Set(reflect.MakeMap(v.Type()))
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fillValue(t, name, elem)
+		v.SetMapIndex(reflect.ValueOf("foo"), elem)
+	case v.Kind() == reflect.Struct:
+		fillFields(t, v)
+	default:
+		t.Fatalf("can not fill the field %v of type %v", name, v.Type())
+	}
+}
+
+// jsonTags returns the json names of the fields of the struct
+func jsonTags(v interface{}) map[string]bool {
+	tags := map[string]bool{}
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		tags[name] = true
+	}
+	return tags
+}
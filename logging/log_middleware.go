@@ -1,16 +1,56 @@
 package logging
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"reflect"
 	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type LogMiddleware struct {
-	Next      http.Handler
-	panicCode int
+	// the counters are accessed atomically and the first fields to be 64-bit aligned on 32-bit platforms
+	inFlight               int64
+	statusCounts           [6]int64
+	Next                   http.Handler
+	panicCode              int
+	accessSink             func(AccessRecord)
+	apiVersion             func(*http.Request) string
+	logCacheControl        bool
+	baggageKeys            []string
+	statusTrailer          string
+	excludedPaths          map[string]bool
+	excludedPrefix         []string
+	sampleRate             float64
+	random                 func() float64
+	logType                string
+	observer               RequestObserver
+	correlationHeaderName  string
+	fieldEnricher          func(*http.Request) logrus.Fields
+	responseHeaders        []string
+	skipSuccessfulBodyless bool
+	handlerName            string
+	panicStackTrace        bool
+	requestId              bool
+	beforeHook             func(*http.Request)
+	panicHandler           func(http.ResponseWriter, *http.Request, interface{})
+	bodyCapture            func(statusCode int) bool
+	bodyContentTypes       []string
+	errorsOnly             bool
+	slowThreshold          time.Duration
+	contextLogger          bool
 }
 
 type LogOption func(*LogMiddleware)
@@ -19,7 +59,9 @@ type LogOption func(*LogMiddleware)
 // Further configuration can be done by passing relevant option functions.
 func NewLogMiddleware(next http.Handler, options ...LogOption) *LogMiddleware {
 	lmw := &LogMiddleware{
-		Next: next,
+		Next:       next,
+		sampleRate: 1,
+		random:     rand.Float64,
 	}
 	for i := range options {
 		options[i](lmw)
@@ -35,23 +77,462 @@ func WithPanicStatus(statusCode int) LogOption {
 	}
 }
 
+// WithPanicStackTrace modifies the middleware so that the stack trace of a panic is logged in the stack field.
+func WithPanicStackTrace() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.panicStackTrace = true
+	}
+}
+
+// WithRequestBody modifies the middleware so that the bodies of requests with one of the given content types
+// (e.g. application/json) are logged, if the capture function returns true for the response status.
+// The bodies are limited to RequestBodyMaxSize and redacted like the bodies of calls.
+// Only JSON and url encoded form bodies are captured, as other bodies can not be redacted.
+func WithRequestBody(capture func(statusCode int) bool, contentTypes ...string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.bodyCapture = capture
+		lmw.bodyContentTypes = contentTypes
+	}
+}
+
+// WithPanicHandler modifies the middleware so that the given function handles the response after a panic was logged,
+// e.g. to render an error body. It is called instead of writing the status of WithPanicStatus.
+func WithPanicHandler(handler func(w http.ResponseWriter, r *http.Request, rec interface{})) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.panicHandler = handler
+	}
+}
+
+// WithAccessSink modifies the middleware so that every access record is additionally passed to the given sink.
+// This allows routing the structured access data into other systems than logrus.
+func WithAccessSink(sink func(AccessRecord)) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.accessSink = sink
+	}
+}
+
+// WithApiVersion modifies the middleware so that the api version, extracted by the given function, is logged.
+// The function may e.g. inspect the path or the Accept header and should return an empty string if no version matches.
+func WithApiVersion(extract func(*http.Request) string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.apiVersion = extract
+	}
+}
+
+// WithCacheControl modifies the middleware so that the Cache-Control headers of the request and the response are logged.
+func WithCacheControl() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.logCacheControl = true
+	}
+}
+
+// WithBaggage modifies the middleware so that the given entries of the baggage header are logged.
+func WithBaggage(keys ...string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.baggageKeys = keys
+	}
+}
+
+// WithStatusTrailer modifies the middleware so that the status code set by the handler in the given trailer
+// overrides the logged status code. This allows streaming responses to log their final outcome.
+func WithStatusTrailer(trailer string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.statusTrailer = trailer
+	}
+}
+
+// WithExcludePaths modifies the middleware so that no access log is written for the given paths, e.g. health checks.
+// Paths ending with * match all paths with the given prefix. Panics are still logged for excluded paths.
+func WithExcludePaths(paths ...string) LogOption {
+	return func(lmw *LogMiddleware) {
+		if lmw.excludedPaths == nil {
+			lmw.excludedPaths = map[string]bool{}
+		}
+		for _, path := range paths {
+			if strings.HasSuffix(path, "*") {
+				lmw.excludedPrefix = append(lmw.excludedPrefix, strings.TrimSuffix(path, "*"))
+			} else {
+				lmw.excludedPaths[path] = true
+			}
+		}
+	}
+}
+
+// WithSampling modifies the middleware so that only the given fraction (0..1) of the successful (2xx/3xx) requests
+// is logged. Requests with other status codes are always logged and the access sink still receives all requests.
+func WithSampling(rate float64) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.sampleRate = rate
+	}
+}
+
+// WithLogType modifies the middleware so that the access logs are written with the given type instead of "access".
+func WithLogType(logType string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.logType = logType
+	}
+}
+
+// WithMetrics modifies the middleware so that every completed request is passed to the given observer.
+func WithMetrics(observer RequestObserver) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.observer = observer
+	}
+}
+
+// WithCorrelationHeader modifies the middleware so that the correlation id is read from and set to the given header
+// instead of the CorrelationIdHeader.
+func WithCorrelationHeader(name string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.correlationHeaderName = name
+	}
+}
+
+// WithFieldEnricher modifies the middleware so that the fields returned by the given function are added to the access log.
+// The function is called after the handler, so it can read context values set during the handling.
+// WithContextLogger, the fields are also added to the entry of LoggerFromContext, for which the function is called before the handler.
+func WithFieldEnricher(enrich func(*http.Request) logrus.Fields) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.fieldEnricher = enrich
+	}
+}
+
+// WithResponseHeaders modifies the middleware so that the given response headers are logged.
+func WithResponseHeaders(names ...string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.responseHeaders = names
+	}
+}
+
+// WithSkipSuccessfulBodyless modifies the middleware so that no access log is written for successful (2xx)
+// GET and HEAD requests without a query string. The access sink still receives all requests.
+func WithSkipSuccessfulBodyless() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.skipSuccessfulBodyless = true
+	}
+}
+
+// WithErrorsOnly modifies the middleware so that access logs are only written for responses with a status >= 400
+// or an error set with SetRequestError. Panics are always logged and the access sink still receives all requests.
+func WithErrorsOnly() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.errorsOnly = true
+	}
+}
+
+// WithSlowRequestThreshold modifies the middleware so that requests taking longer than the threshold
+// are logged with slow=true at least at warn level, regardless of their status, sampling and skipping.
+func WithSlowRequestThreshold(threshold time.Duration) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.slowThreshold = threshold
+	}
+}
+
+// WithHandlerName modifies the middleware so that the access logs are tagged with the given handler name.
+func WithHandlerName(name string) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.handlerName = name
+	}
+}
+
+// WithRequestId modifies the middleware so that it logs a generated request_id for requests without correlation id,
+// instead of setting a generated correlation id to them. The request headers are left untouched.
+func WithRequestId() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.requestId = true
+	}
+}
+
+// WithContextLogger modifies the middleware so that it prepares an entry for application logs of the request,
+// carrying its correlation ids, which handlers retrieve with LoggerFromContext.
+func WithContextLogger() LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.contextLogger = true
+	}
+}
+
+// WithBeforeHook modifies the middleware so that the given function is called for every request before the handler,
+// e.g. to log the arrival of long-running requests. The correlation id is already set to the request.
+func WithBeforeHook(hook func(*http.Request)) LogOption {
+	return func(lmw *LogMiddleware) {
+		lmw.beforeHook = hook
+	}
+}
+
+// InFlight returns the number of requests, which are currently handled by the middleware.
+func (mw *LogMiddleware) InFlight() int64 {
+	return atomic.LoadInt64(&mw.inFlight)
+}
+
+// StatusCounts returns the number of responses served so far by status class, e.g. 2xx.
+func (mw *LogMiddleware) StatusCounts() map[string]int64 {
+	counts := map[string]int64{}
+	for class := 1; class <= 5; class++ {
+		counts[fmt.Sprintf("%dxx", class)] = atomic.LoadInt64(&mw.statusCounts[class])
+	}
+	return counts
+}
+
+func (mw *LogMiddleware) countStatus(statusCode int) {
+	if class := statusCode / 100; class >= 1 && class <= 5 {
+		atomic.AddInt64(&mw.statusCounts[class], 1)
+	}
+}
+
 func (mw *LogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	EnsureCorrelationId(r)
-	start := time.Now()
+	atomic.AddInt64(&mw.inFlight, 1)
+	defer atomic.AddInt64(&mw.inFlight, -1)
 
+	var correlationId string
+	generated := false
+	if mw.requestId {
+		correlationId = getRequestCorrelationId(r, mw.correlationHeader())
+	} else {
+		correlationId, generated = ensureCorrelationId(r, mw.correlationHeader())
+	}
+	start := Now()
+	r, lc := withLogContext(r)
+	lc.correlationId = correlationId
+	lc.correlationIdGenerated = generated
+	r = withCorrelationIdContext(r, correlationId)
+	if mw.requestId && correlationId == "" {
+		lc.requestId = CorrelationIdGenerator()
+	}
+	if mw.contextLogger {
+		lc.entry = mw.contextEntry(r, lc)
+	}
+	if mw.beforeHook != nil {
+		mw.beforeHook(r)
+	}
+
+	lrw := &logResponseWriter{ResponseWriter: w, statusCode: 200}
 	defer func() {
 		if rec := recover(); rec != nil {
-			AccessError(r, start, fmt.Errorf("PANIC (%v): %v", identifyLogOrigin(), rec))
-			if mw.panicCode != 0 {
-				w.WriteHeader(mw.panicCode)
+			record := mw.newAccessRecord(w, r, start, 0, fmt.Errorf("PANIC (%v): %v", identifyLogOrigin(), rec))
+			record.PanicCategory = panicCategory(rec)
+			if mw.panicStackTrace {
+				record.Stack = string(debug.Stack())
 			}
+			logAccessError(r, record)
+			mw.emit(record)
+			if mw.panicHandler != nil {
+				mw.handlePanic(lrw, r, start, rec)
+			} else if mw.panicCode != 0 {
+				// a status written by the handler before the panic can not be changed
+				if !lrw.wroteHeader {
+					lrw.WriteHeader(mw.panicCode)
+				}
+
+				// log the response status in addition to the error
+				record := mw.newAccessRecord(w, r, start, lrw.statusCode, nil)
+				logAccess(r, record)
+				mw.emit(record)
+			}
+			// without a written response, the server responds with the status written so far, 200 by default
+			mw.countStatus(lrw.statusCode)
+			mw.observe(r, start, lrw.statusCode)
 		}
 	}()
 
-	lrw := &logResponseWriter{ResponseWriter: w, statusCode: 200}
+	var body string
+	if mw.capturesBody(r) {
+		// bodies which can not be redacted are never logged
+		if redacted, ok := captureBody(r, RequestBodyMaxSize); ok {
+			body = redacted
+		}
+	}
+
 	mw.Next.ServeHTTP(lrw, r)
 
-	Access(r, start, lrw.statusCode)
+	statusCode := lrw.statusCode
+	if lrw.hijacked {
+		statusCode = 0
+	} else if mw.statusTrailer != "" {
+		if trailerStatus, err := strconv.Atoi(lrw.trailer(mw.statusTrailer)); err == nil {
+			statusCode = trailerStatus
+		}
+	}
+
+	mw.countStatus(statusCode)
+
+	mw.observe(r, start, statusCode)
+
+	if mw.isExcluded(r.URL.Path) {
+		return
+	}
+
+	record := mw.newAccessRecord(w, r, start, statusCode, nil)
+	record.ResponseSize = lrw.bytesWritten
+	record.Hijacked = lrw.hijacked
+	if body != "" && mw.bodyCapture(statusCode) {
+		record.RequestBody = body
+	}
+	// the context of the request is canceled, if the client closes the connection
+	record.ClientDisconnected = r.Context().Err() == context.Canceled
+	lc.mu.Lock()
+	record.RateLimit = lc.rateLimit
+	lc.mu.Unlock()
+	record.Slow = mw.slowThreshold > 0 && record.Duration > mw.slowThreshold
+	if record.Slow || !mw.isSampledOut(record.ResponseStatus) && !mw.isSkipped(r, record) {
+		logAccess(r, record)
+	}
+	mw.emit(record)
+}
+
+// withCorrelationIdContext adds the given correlation id and the user correlation id of the request headers to its context
+func withCorrelationIdContext(r *http.Request, correlationId string) *http.Request {
+	ctx := r.Context()
+	if correlationId != "" {
+		ctx = ContextWithCorrelationId(ctx, correlationId)
+	}
+	if id := GetUserCorrelationId(r.Header); id != "" {
+		ctx = ContextWithUserCorrelationId(ctx, id)
+	}
+	return r.WithContext(ctx)
+}
+
+// observe passes the request to the observer, if one is set
+func (mw *LogMiddleware) observe(r *http.Request, start time.Time, statusCode int) {
+	if mw.observer == nil {
+		return
+	}
+	path := getRoute(r.Context())
+	if path == "" {
+		path = UnmatchedRoute
+	}
+	mw.observer.Observe(r.Method, path, statusCode, Now().Sub(start))
+}
+
+// handlePanic calls the panic handler and logs the response status written so far.
+// A panic of the handler itself is recovered and logged.
+func (mw *LogMiddleware) handlePanic(lrw *logResponseWriter, r *http.Request, start time.Time, rec interface{}) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			record := mw.newAccessRecord(lrw, r, start, 0, fmt.Errorf("PANIC in panic handler (%v): %v", identifyLogOrigin(), rec))
+			logAccessError(r, record)
+		}
+	}()
+
+	handlerLrw := &logResponseWriter{ResponseWriter: lrw, statusCode: 200}
+	mw.panicHandler(handlerLrw, r, rec)
+
+	record := mw.newAccessRecord(lrw, r, start, lrw.statusCode, nil)
+	record.ResponseSize = handlerLrw.bytesWritten
+	logAccess(r, record)
+	mw.emit(record)
+}
+
+// capturesBody returns true, if the content type of the request is one of the captured content types
+func (mw *LogMiddleware) capturesBody(r *http.Request) bool {
+	if mw.bodyCapture == nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return isRedactable(mediaType) && containsFold(mw.bodyContentTypes, mediaType)
+}
+
+func (mw *LogMiddleware) isSampledOut(statusCode int) bool {
+	if mw.sampleRate >= 1 || statusCode < 200 || statusCode > 399 {
+		return false
+	}
+	return mw.random() >= mw.sampleRate
+}
+
+func (mw *LogMiddleware) isSkipped(r *http.Request, record AccessRecord) bool {
+	if mw.errorsOnly && record.ResponseStatus < 400 && record.Error == nil {
+		return true
+	}
+	statusCode := record.ResponseStatus
+	if !mw.skipSuccessfulBodyless || statusCode < 200 || statusCode > 299 || r.URL.RawQuery != "" {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+func (mw *LogMiddleware) isExcluded(path string) bool {
+	if mw.excludedPaths[path] {
+		return true
+	}
+	for _, prefix := range mw.excludedPrefix {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mw *LogMiddleware) newAccessRecord(w http.ResponseWriter, r *http.Request, start time.Time, statusCode int, err error) AccessRecord {
+	record := NewAccessRecord(r, start, statusCode, err)
+	record.CorrelationId = r.Header.Get(mw.correlationHeader())
+	if lc := getLogContext(r.Context()); lc != nil {
+		record.CorrelationId = lc.correlationId
+		record.RequestId = lc.requestId
+		if record.CorrelationId != "" {
+			generated := lc.correlationIdGenerated
+			record.CorrelationIdGenerated = &generated
+		}
+	}
+	if mw.logType != "" {
+		record.Type = mw.logType
+	}
+	record.Handler = mw.handlerName
+	if mw.apiVersion != nil {
+		record.ApiVersion = mw.apiVersion(r)
+	}
+	if len(mw.baggageKeys) > 0 {
+		record.Baggage = GetBaggage(r.Header, mw.baggageKeys)
+	}
+	if mw.fieldEnricher != nil {
+		fields := mw.fieldEnricher(r)
+		if len(fields) > 0 && record.CustomFields == nil {
+			record.CustomFields = logrus.Fields{}
+		}
+		for k, v := range fields {
+			record.CustomFields[k] = v
+		}
+	}
+	record.ContentEncoding = w.Header().Get("Content-Encoding")
+	for _, name := range mw.responseHeaders {
+		if value := w.Header().Get(name); value != "" {
+			if record.ResponseHeaders == nil {
+				record.ResponseHeaders = map[string]string{}
+			}
+			record.ResponseHeaders[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if mw.logCacheControl {
+		record.RequestCacheControl = r.Header.Get("Cache-Control")
+		record.ResponseCacheControl = w.Header().Get("Cache-Control")
+	}
+	return record
+}
+
+func (mw *LogMiddleware) correlationHeader() string {
+	if mw.correlationHeaderName != "" {
+		return mw.correlationHeaderName
+	}
+	return CorrelationIdHeader
+}
+
+func (mw *LogMiddleware) emit(record AccessRecord) {
+	if mw.accessSink != nil {
+		mw.accessSink(record)
+	}
+}
+
+// panicCategory classifies a recovered value into
+// runtime-error (e.g. nil pointer or index out of range), error-value or explicit-panic
+func panicCategory(rec interface{}) string {
+	switch rec.(type) {
+	case runtime.Error:
+		return "runtime-error"
+	case error:
+		return "error-value"
+	}
+	return "explicit-panic"
 }
 
 // identifyLogOrigin returns the location, where a panic was raised
@@ -69,7 +550,7 @@ func identifyLogOrigin() string {
 		}
 		file, line = fn.FileLine(pc)
 		name = fn.Name()
-		if !strings.HasPrefix(name, "runtime.") {
+		if !strings.HasPrefix(name, "runtime.") && !isLoggingFrame(name, file) {
 			break
 		}
 	}
@@ -84,16 +565,85 @@ func identifyLogOrigin() string {
 	return fmt.Sprintf("pc:%x", pc)
 }
 
+// loggingPackagePrefix is the prefix of the function names of this package, e.g. github.com/.../logging.
+var loggingPackagePrefix = reflect.TypeOf(LogMiddleware{}).PkgPath() + "."
+
+// isLoggingFrame returns true for frames of this package, so that panics raised within it
+// are reported at the calling application code. Tests of the package count as application code.
+func isLoggingFrame(name, file string) bool {
+	return strings.HasPrefix(name, loggingPackagePrefix) && !strings.HasSuffix(file, "_test.go")
+}
+
 type logResponseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int
+	hijacked     bool
+	wroteHeader  bool
 }
 
 func (lrw *logResponseWriter) Write(b []byte) (int, error) {
-	return lrw.ResponseWriter.Write(b)
+	lrw.wroteHeader = true
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytesWritten += n
+	return n, err
 }
 
+// WriteHeader records the final status code. Like by the server, informational status codes and later calls are not recorded.
 func (lrw *logResponseWriter) WriteHeader(statusCode int) {
-	lrw.statusCode = statusCode
+	if !lrw.wroteHeader && (statusCode >= 200 || statusCode == http.StatusSwitchingProtocols) {
+		lrw.statusCode = statusCode
+		lrw.wroteHeader = true
+	}
 	lrw.ResponseWriter.WriteHeader(statusCode)
 }
+
+// StatusCode returns the status code written so far, 200 if none was written explicitly.
+// Handlers can read it with a type assertion to interface{ StatusCode() int }.
+func (lrw *logResponseWriter) StatusCode() int {
+	return lrw.statusCode
+}
+
+// Flush implements http.Flusher, if the underlying ResponseWriter supports flushing
+func (lrw *logResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the underlying ResponseWriter supports hijacking
+func (lrw *logResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := lrw.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := h.Hijack()
+		if err == nil {
+			lrw.hijacked = true
+		}
+		return conn, rw, err
+	}
+	return nil, nil, errors.New("the underlying ResponseWriter does not implement http.Hijacker")
+}
+
+// Push implements http.Pusher, if the underlying ResponseWriter supports server push
+func (lrw *logResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := lrw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// trailer returns the value of a trailer set by the handler,
+// either declared in the Trailer header or set with the http.TrailerPrefix.
+func (lrw *logResponseWriter) trailer(name string) string {
+	h := lrw.Header()
+	if value := h.Get(http.TrailerPrefix + name); value != "" {
+		return value
+	}
+	for _, declared := range h["Trailer"] {
+		for _, t := range strings.Split(declared, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(t)) == http.CanonicalHeaderKey(name) {
+				return h.Get(name)
+			}
+		}
+	}
+	return ""
+}
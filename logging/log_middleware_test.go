@@ -1,11 +1,22 @@
 package logging
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +25,7 @@ func Test_LogMiddleware_Panic(t *testing.T) {
 
 	// given: a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a handler which raises a panic
 	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -31,6 +42,138 @@ func Test_LogMiddleware_Panic(t *testing.T) {
 	a.Contains(data.Error, "runtime error: index out of range")
 	a.Contains(data.Message, "ERROR ->GET /foo")
 	a.Equal(data.Level, "error")
+	a.Equal("runtime-error", mapFromBuffer(b)["panic_category"])
+
+	// and no access entry with a status is logged without panic status
+	a.Len(logRecordsFromBuffer(b), 1)
+}
+
+func Test_LogMiddleware_PanicStackTrace(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which raises a panic
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panicInHelper()
+	})
+
+	// when: the request is served with stack traces
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	NewLogMiddleware(handler, WithPanicStackTrace()).ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the stack contains the panicking function
+	a.Contains(mapFromBuffer(b)["stack"], "logging.panicInHelper")
+
+	// when: the request is served without stack traces
+	b.Reset()
+	NewLogMiddleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: no stack is logged
+	a.NotContains(mapFromBuffer(b), "stack")
+}
+
+func panicInHelper() {
+	panic("oops")
+}
+
+func Test_LogMiddleware_PanicOriginSkipsLoggingFrames(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which causes a panic within a helper of the logging package
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var lc *logContext
+		lc.customFields()
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the origin is the calling handler
+	data := logRecordFromBuffer(b)
+	a.Contains(data.Error, "logging.Test_LogMiddleware_PanicOriginSkipsLoggingFrames.func1")
+	a.NotContains(data.Error, "customFields")
+}
+
+func Test_LogMiddleware_PanicHandler(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which raises a panic, with a custom panic handler
+	var recovered interface{}
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}), WithPanicStatus(500), WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) {
+		recovered = rec
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(503)
+		w.Write([]byte(`{"error":"internal"}`))
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	rw := httptest.NewRecorder()
+	lm.ServeHTTP(rw, r)
+
+	// then: the response is rendered by the panic handler
+	a.Equal("oops", recovered)
+	a.Equal(503, rw.Code)
+	a.Equal(`{"error":"internal"}`, rw.Body.String())
+
+	// and the error and the response status are logged
+	records := logRecordsFromBuffer(b)
+	a.Len(records, 2)
+	a.Contains(records[0].Error, "oops")
+	a.Equal(503, records[1].ResponseStatus)
+}
+
+func Test_LogMiddleware_PanicHandler_Panics(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a panic handler which panics itself
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}), WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) {
+		panic("again")
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	a.NotPanics(func() { lm.ServeHTTP(httptest.NewRecorder(), r) })
+
+	// then: both panics are logged
+	records := logRecordsFromBuffer(b)
+	a.Len(records, 2)
+	a.Contains(records[0].Error, "oops")
+	a.Contains(records[1].Error, "PANIC in panic handler")
+	a.Contains(records[1].Error, "again")
+}
+
+func Test_LogMiddleware_PanicCategory(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("runtime-error", panicCategory(func() (rec interface{}) {
+		defer func() { rec = recover() }()
+		var m map[string]int
+		m["foo"] = 1
+		return nil
+	}()))
+	a.Equal("error-value", panicCategory(errors.New("oops")))
+	a.Equal("explicit-panic", panicCategory("oops"))
 }
 
 func Test_LogMiddleware_Panic_With_500_Resp(t *testing.T) {
@@ -38,7 +181,7 @@ func Test_LogMiddleware_Panic_With_500_Resp(t *testing.T) {
 
 	// given: a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a handler which raises a panic and returns a 500 response
 	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -50,12 +193,21 @@ func Test_LogMiddleware_Panic_With_500_Resp(t *testing.T) {
 	rw := httptest.NewRecorder()
 	lm.ServeHTTP(rw, r)
 
-	data := logRecordFromBuffer(b)
+	records := logRecordsFromBuffer(b)
+	a.Len(records, 2)
+	data := records[0]
 	a.Equal(rw.Code, 500)
 	a.Contains(data.Error, "logging.Test_LogMiddleware_Panic_With_500_Resp.func1")
 	a.Contains(data.Error, "runtime error: index out of range")
 	a.Contains(data.Message, "ERROR ->GET /foo")
 	a.Equal(data.Level, "error")
+
+	// and the response status is logged
+	data = records[1]
+	a.Equal(500, data.ResponseStatus)
+	a.Equal("500 ->GET /foo", data.Message)
+	a.Equal("", data.Error)
+	a.Equal("error", data.Level)
 }
 
 func Test_LogMiddleware_Log_implicit200(t *testing.T) {
@@ -63,7 +215,7 @@ func Test_LogMiddleware_Log_implicit200(t *testing.T) {
 
 	// given: a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a handler which gets an 200er code implicitly
 	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -86,7 +238,7 @@ func Test_LogMiddleware_Log_404(t *testing.T) {
 
 	// given: a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a handler which gets an 404er code explicitly
 	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -109,7 +261,7 @@ func Test_LogMiddleware_Log_Default_Response_Code(t *testing.T) {
 
 	// given: a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a handler which gets no explicit response code (default 200)
 	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -126,3 +278,1230 @@ func Test_LogMiddleware_Log_Default_Response_Code(t *testing.T) {
 	a.Equal(200, data.ResponseStatus)
 	a.Equal("info", data.Level)
 }
+
+func Test_LogMiddleware_Log_Head(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which writes a body, which is discarded for HEAD requests by net/http
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "5")
+		w.Write([]byte("hello"))
+	}))
+
+	// when: a HEAD request is served
+	r, _ := http.NewRequest("HEAD", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the method is logged and no response size is claimed
+	data := mapFromBuffer(b)
+	a.Equal("HEAD", data["method"])
+	a.Equal("200 ->HEAD /foo", data["message"])
+	a.NotContains(data, "response_size")
+
+	// when: a GET request is served by the same handler
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the response size is logged
+	a.Equal(5.0, mapFromBuffer(b)["response_size"])
+}
+
+func Test_LogMiddleware_AccessSink(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware with an access sink
+	var records []AccessRecord
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}), WithAccessSink(func(record AccessRecord) {
+		records = append(records, record)
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo?q=bar", nil)
+
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the record is passed to the sink
+	a.Len(records, 1)
+	a.Equal("GET", records[0].Method)
+	a.Equal("/foo?q=bar", records[0].URL)
+	a.Equal(404, records[0].ResponseStatus)
+	a.NotEmpty(records[0].CorrelationId)
+
+	// and the access entry is still logged
+	data := logRecordFromBuffer(b)
+	a.Equal("404 ->GET /foo?...", data.Message)
+}
+
+func Test_LogMiddleware_ApiVersion(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware extracting the api version from the path
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	}), WithApiVersion(func(r *http.Request) string {
+		return strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/v2/foo", nil)
+
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the api version is logged
+	data := mapFromBuffer(b)
+	a.Equal("v2", data["api_version"])
+}
+
+func Test_LogMiddleware_CacheControl(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware logging the cache control headers
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+	}), WithCacheControl())
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("Cache-Control", "no-cache")
+
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: both headers are logged
+	data := mapFromBuffer(b)
+	a.Equal("no-cache", data["request_cache_control"])
+	a.Equal("max-age=60", data["response_cache_control"])
+}
+
+func Test_LogMiddleware_Baggage(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware logging selected baggage entries
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	}), WithBaggage("tier"))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(BaggageHeader, "tier=gold,region=eu")
+
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: only the selected entries are logged
+	data := mapFromBuffer(b)
+	a.Equal(map[string]interface{}{"tier": "gold"}, data["baggage"])
+}
+
+func Test_LogMiddleware_StatusTrailer(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a streaming handler reporting its outcome in a declared trailer
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Status")
+		w.Write([]byte("chunk"))
+		w.Header().Set("X-Status", "500")
+	}), WithStatusTrailer("X-Status"))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the trailer status is logged
+	data := logRecordFromBuffer(b)
+	a.Equal(500, data.ResponseStatus)
+	a.Equal("error", data.Level)
+
+	// when: the trailer is set using the trailer prefix
+	b.Reset()
+	lm = NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		w.Header().Set(http.TrailerPrefix+"X-Status", "503")
+	}), WithStatusTrailer("X-Status"))
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the trailer status is logged
+	a.Equal(503, logRecordFromBuffer(b).ResponseStatus)
+
+	// when: no trailer is set
+	b.Reset()
+	lm = NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+	}), WithStatusTrailer("X-Status"))
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the written status is logged
+	a.Equal(200, logRecordFromBuffer(b).ResponseStatus)
+}
+
+func Test_LogMiddleware_RateLimit(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which throttles the request
+	reset := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRateLimit(r, RateLimit{Limit: 100, Remaining: 0, Reset: reset, Throttled: true})
+		w.WriteHeader(429)
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the rate limit is logged
+	data := mapFromBuffer(b)
+	a.Equal(100.0, data["rate_limit"])
+	a.Equal(0.0, data["rate_limit_remaining"])
+	a.Equal("2020-01-01T12:00:00Z", data["rate_limit_reset"])
+	a.Equal(true, data["throttled"])
+
+	// when: no rate limit is set
+	b.Reset()
+	NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: no rate limit is logged
+	a.NotContains(mapFromBuffer(b), "rate_limit")
+}
+
+func Test_LogMiddleware_ResponseSize(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which writes the body in multiple chunks
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+		w.Write([]byte(" world"))
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the size of all chunks is logged
+	a.Equal(11.0, mapFromBuffer(b)["response_size"])
+
+	// when: nothing is written
+	b.Reset()
+	NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the size is 0
+	a.Equal(0.0, mapFromBuffer(b)["response_size"])
+}
+
+func Test_LogMiddleware_ExcludePaths(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware excluding the health checks
+	var correlationId string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationId = GetCorrelationId(r.Header)
+		if r.URL.Path == "/healthz/panic" {
+			panic("oops")
+		}
+	}), WithExcludePaths("/readyz", "/healthz/*"))
+
+	// when: excluded paths are requested
+	for _, path := range []string{"/readyz", "/healthz/live"} {
+		r, _ := http.NewRequest("GET", "http://www.example.org"+path, nil)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: nothing is logged, but the correlation id is set
+	a.Equal("", b.String())
+	a.NotEmpty(correlationId)
+
+	// when: an excluded path panics
+	r, _ := http.NewRequest("GET", "http://www.example.org/healthz/panic", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the panic is logged
+	a.Contains(logRecordFromBuffer(b).Error, "oops")
+
+	// when: another path is requested
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/readyz/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is logged
+	a.Equal("200 ->GET /readyz/foo", logRecordFromBuffer(b).Message)
+}
+
+func Test_LogMiddleware_Flusher(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a handler which flushes the response
+	var isFlusher bool
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var f http.Flusher
+		f, isFlusher = w.(http.Flusher)
+		w.Write([]byte("event"))
+		f.Flush()
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/events", nil)
+	rw := httptest.NewRecorder()
+	lm.ServeHTTP(rw, r)
+
+	// then: the flush is passed to the underlying writer
+	a.True(isFlusher)
+	a.True(rw.Flushed)
+}
+
+func Test_LogMiddleware_Pusher(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a writer without push support
+	lrw := &logResponseWriter{ResponseWriter: httptest.NewRecorder()}
+
+	// then: push is not supported
+	a.Equal(http.ErrNotSupported, lrw.Push("/style.css", nil))
+}
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func Test_LogMiddleware_Hijack(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler which hijacks the connection
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	var hijackErr error
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hijackErr = w.(http.Hijacker).Hijack()
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/ws", nil)
+	lm.ServeHTTP(&hijackableRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}, r)
+
+	// then: the hijack is passed to the underlying writer and no status is logged
+	a.NoError(hijackErr)
+	data := mapFromBuffer(b)
+	a.Equal("HIJACKED ->GET /ws", data["message"])
+	a.Equal("info", data["level"])
+	a.Equal(true, data["hijacked"])
+	a.NotContains(data, "response_status")
+
+	// when: the underlying writer does not support hijacking
+	b.Reset()
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: an error is returned and the status is logged
+	a.Error(hijackErr)
+	a.Equal("200 ->GET /ws", logRecordFromBuffer(b).Message)
+}
+
+func Test_LogMiddleware_Hijack_AccessMessageFormatter(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger with a custom message formatter
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	AccessMessageFormatter = func(r *http.Request, statusCode int) string {
+		return fmt.Sprintf("%v %v status=%v", r.Method, r.URL.Path, statusCode)
+	}
+	defer func() { AccessMessageFormatter = DefaultAccessMessage }()
+
+	// and a handler which hijacks the connection
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Hijacker).Hijack()
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/ws", nil)
+	lm.ServeHTTP(&hijackableRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}, r)
+
+	// then: the message is formatted by it with an unknown status
+	data := mapFromBuffer(b)
+	a.Equal("GET /ws status=0", data["message"])
+	a.Equal(true, data["hijacked"])
+}
+
+func Test_LogMiddleware_Sampling(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware sampling 25% of the requests with a deterministic random source
+	statusCode := 200
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}), WithSampling(0.25))
+	values := []float64{0.1, 0.3, 0.6, 0.9}
+	i := 0
+	lm.random = func() float64 {
+		v := values[i%len(values)]
+		i++
+		return v
+	}
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: successful requests are served
+	for j := 0; j < 8; j++ {
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: the sample rate is honored
+	a.Equal(2, strings.Count(b.String(), "\n"))
+
+	// when: failed requests are served
+	b.Reset()
+	for _, statusCode = range []int{404, 500, 503, 400} {
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: they are never dropped
+	a.Equal(4, strings.Count(b.String(), "\n"))
+}
+
+func Test_LogMiddleware_SkipSuccessfulBodyless(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware skipping successful plain requests
+	statusCode := 200
+	records := 0
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}), WithSkipSuccessfulBodyless(), WithAccessSink(func(AccessRecord) { records++ }))
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		logged bool
+	}{
+		{"GET", "http://www.example.org/foo", 200, false},
+		{"GET", "http://www.example.org/foo?", 200, false},
+		{"HEAD", "http://www.example.org/foo", 204, false},
+		{"GET", "http://www.example.org/foo", 299, false},
+		{"GET", "http://www.example.org/foo?q=bar", 200, true},
+		{"HEAD", "http://www.example.org/foo?q=bar", 200, true},
+		{"POST", "http://www.example.org/foo", 200, true},
+		{"DELETE", "http://www.example.org/foo", 204, true},
+		{"GET", "http://www.example.org/foo", 101, true},
+		{"GET", "http://www.example.org/foo", 304, true},
+		{"GET", "http://www.example.org/foo", 404, true},
+		{"GET", "http://www.example.org/foo", 500, true},
+	}
+	for _, test := range tests {
+		b.Reset()
+		statusCode = test.status
+
+		// when: the request is served
+		r, _ := http.NewRequest(test.method, test.url, nil)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+
+		// then: it is only logged if it is not a successful plain request
+		a.Equal(test.logged, b.Len() > 0, "%v %v -> %v", test.method, test.url, test.status)
+	}
+
+	// and the access sink receives all requests
+	a.Equal(len(tests), records)
+}
+
+func Test_LogMiddleware_StatusCode(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a handler reading back the status code
+	var before, after int
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, ok := w.(interface{ StatusCode() int })
+		a.True(ok)
+		before = sw.StatusCode()
+		w.WriteHeader(201)
+		after = sw.StatusCode()
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the effective status is returned
+	a.Equal(200, before)
+	a.Equal(201, after)
+}
+
+func Test_LogMiddleware_ContentEncoding(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler compressing its response
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the encoding is logged
+	data := mapFromBuffer(b)
+	a.Equal("gzip", data["content_encoding"])
+
+	// when: a response without encoding is served
+	b.Reset()
+	NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the field is omitted
+	a.NotContains(mapFromBuffer(b), "content_encoding")
+}
+
+func Test_LogMiddleware_HandlerName(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a mux with named handlers
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/orders", NewLogMiddleware(handler, WithHandlerName("orders")))
+	mux.Handle("/users", NewLogMiddleware(handler, WithHandlerName("users")))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/users", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the name of the serving handler is logged
+	data := mapFromBuffer(b)
+	a.Equal("users", data["handler"])
+
+	// when: a request is served by a middleware without name
+	b.Reset()
+	NewLogMiddleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: no handler is logged
+	a.NotContains(mapFromBuffer(b), "handler")
+}
+
+func Test_LogMiddleware_ClientDisconnected(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a client, which goes away during the handling
+	ctx, cancel := context.WithCancel(context.Background())
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+	}))
+
+	// when: the request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+
+	// then: the disconnect is logged
+	data := mapFromBuffer(b)
+	a.Equal(true, data["client_disconnected"])
+
+	// when: a request is completed normally
+	b.Reset()
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: no disconnect is logged
+	a.NotContains(mapFromBuffer(b), "client_disconnected")
+}
+
+func Test_LogMiddleware_RequestId(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware generating request ids
+	var handlerHeader http.Header
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerHeader = r.Header.Clone()
+	}), WithRequestId())
+
+	// when: a request without correlation id is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the headers are not modified
+	a.Empty(r.Header.Get(CorrelationIdHeader))
+	a.Empty(handlerHeader.Get(CorrelationIdHeader))
+
+	// and the request id is logged
+	data := mapFromBuffer(b)
+	a.NotEmpty(data["request_id"])
+	a.NotContains(data, "correlation_id")
+
+	// when: a second request is served
+	b.Reset()
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it has its correlation id, but no request id
+	second := mapFromBuffer(b)
+	a.NotContains(second, "request_id")
+	a.Equal("correlation-123", second["correlation_id"])
+
+	// when: a request with a correlation id under an alias is served
+	CorrelationIdHeaderAliases = []string{"X-Request-Id"}
+	defer func() { CorrelationIdHeaderAliases = nil }()
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("X-Request-Id", "alias-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: no request id is generated either
+	aliased := mapFromBuffer(b)
+	a.NotContains(aliased, "request_id")
+	a.Equal("alias-123", aliased["correlation_id"])
+
+	// when: a further request without correlation id is served
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it has another request id
+	third := mapFromBuffer(b)
+	a.NotEmpty(third["request_id"])
+	a.NotEqual(data["request_id"], third["request_id"])
+}
+
+func Test_LogMiddleware_RequestId_AliasedCorrelationId(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware generating request ids with a context logger
+	CorrelationIdHeaderAliases = []string{"X-Request-Id"}
+	defer func() { CorrelationIdHeaderAliases = nil }()
+	var contextCorrelationId string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextCorrelationId = CorrelationIdFromContext(r.Context())
+		LoggerFromContext(r.Context()).Info("hello")
+	}), WithRequestId(), WithContextLogger())
+
+	for name, r := range map[string]*http.Request{
+		"alias":   httptest.NewRequest("GET", "http://www.example.org/foo", nil),
+		"context": httptest.NewRequest("GET", "http://www.example.org/foo", nil),
+	} {
+		if name == "alias" {
+			r.Header.Set("X-Request-Id", "abc")
+		} else {
+			r = r.WithContext(ContextWithCorrelationId(r.Context(), "abc"))
+		}
+
+		// when: a request with a correlation id outside of the correlation header is served
+		b.Reset()
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+
+		// then: its correlation id is logged by the application and access log instead of a request id
+		records := logRecordsFromBuffer(b)
+		a.Len(records, 2, name)
+		for _, record := range records {
+			a.Equal("abc", record.CorrelationId, name)
+		}
+		a.NotContains(b.String(), "request_id", name)
+
+		// and: it is added to the context
+		a.Equal("abc", contextCorrelationId, name)
+	}
+}
+
+func Test_LogMiddleware_BeforeHook(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware with a hook
+	var calls []string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), WithBeforeHook(func(r *http.Request) {
+		calls = append(calls, "hook "+GetCorrelationId(r.Header))
+		AddLogField(r, "in_flight_marker", true)
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the hook runs before the handler with the correlation id
+	a.Equal([]string{"hook correlation-123", "handler"}, calls)
+
+	// and it can add fields to the access log
+	a.Equal(true, mapFromBuffer(b)["in_flight_marker"])
+}
+
+func Test_LogMiddleware_InFlight(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a handler blocking until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	// when: requests are served concurrently
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+			lm.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		<-started
+	}
+
+	// then: they are counted as in flight
+	a.Equal(int64(5), lm.InFlight())
+
+	// when: they are completed
+	close(release)
+	wg.Wait()
+
+	// then: the counter returns to zero
+	a.Equal(int64(0), lm.InFlight())
+}
+
+func Test_LogMiddleware_CorrelationIdContext(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a handler reading the correlation ids from the context
+	var id, userId string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = CorrelationIdFromContext(r.Context())
+		userId = UserCorrelationIdFromContext(r.Context())
+	}))
+	SetOutput(bytes.NewBuffer(nil))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(UserCorrelationIdHeader, "user-correlation-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the ids are available in the context
+	a.Equal(r.Header.Get(CorrelationIdHeader), id)
+	a.NotEmpty(id)
+	a.Equal("user-correlation-123", userId)
+}
+
+func Test_LogMiddleware_CorrelationIdGenerated(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// when: a request without correlation id is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the id is logged as generated
+	data := mapFromBuffer(b)
+	a.NotEmpty(data["correlation_id"])
+	a.Equal(true, data["correlation_id_generated"])
+
+	// when: a request with correlation id is served
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the id is logged as inherited
+	data = mapFromBuffer(b)
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal(false, data["correlation_id_generated"])
+}
+
+func Test_Access_CorrelationIdGenerated(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: a request with correlation id is logged outside of the middleware
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
+	Access(r, time.Now(), 200)
+
+	// then: it is unknown, whether the id was generated
+	data := mapFromBuffer(b)
+	a.Equal("correlation-123", data["correlation_id"])
+	a.NotContains(data, "correlation_id_generated")
+}
+
+func Test_LogMiddleware_StatusCounts(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a middleware
+	statusCode := 200
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode == 0 {
+			panic("oops")
+		}
+		w.WriteHeader(statusCode)
+	}), WithPanicStatus(500))
+
+	// when: requests with varied statuses are served
+	for _, statusCode = range []int{200, 201, 204, 301, 404, 400, 401, 503, 0} {
+		r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: they are counted by status class
+	a.Equal(map[string]int64{"1xx": 0, "2xx": 3, "3xx": 1, "4xx": 3, "5xx": 2}, lm.StatusCounts())
+}
+
+func Test_LogMiddleware_PanicRecords(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a handler which panics
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+
+	for name, test := range map[string]struct {
+		option LogOption
+		status int
+	}{
+		"panic status":  {WithPanicStatus(503), 503},
+		"panic handler": {WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) { w.WriteHeader(502) }), 502},
+	} {
+		// when: the panicking request is served
+		var records []AccessRecord
+		lm := NewLogMiddleware(panicking, test.option, WithAccessSink(func(record AccessRecord) {
+			records = append(records, record)
+		}))
+		r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+
+		// then: the error and the status record are emitted
+		a.Len(records, 2, name)
+		a.Error(records[0].Error, name)
+		a.Equal(test.status, records[1].ResponseStatus, name)
+
+		// and: the status is counted
+		a.Equal(int64(1), lm.StatusCounts()["5xx"], name)
+	}
+}
+
+func Test_LogMiddleware_RequestBody(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware capturing json bodies of 4xx responses
+	var handlerBody string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		handlerBody = string(body)
+		w.WriteHeader(400)
+	}), WithRequestBody(func(statusCode int) bool { return statusCode >= 400 && statusCode <= 499 }, "application/json"))
+
+	// when: a json request is rejected
+	r := httptest.NewRequest("POST", "http://www.example.org/foo", strings.NewReader(`{"name":"foo"}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the body is logged
+	a.Equal(`{"name":"foo"}`, mapFromBuffer(b)["request_body"])
+
+	// and the handler could still read it
+	a.Equal(`{"name":"foo"}`, handlerBody)
+
+	// when: a multipart upload is rejected
+	b.Reset()
+	r = httptest.NewRequest("POST", "http://www.example.org/foo", strings.NewReader("--boundary--"))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the body is not logged
+	a.NotContains(mapFromBuffer(b), "request_body")
+	a.Equal("--boundary--", handlerBody)
+
+	// when: a json request larger than the captured size is rejected
+	b.Reset()
+	largeBody := `{"name":"` + strings.Repeat("x", RequestBodyMaxSize) + `"}`
+	r = httptest.NewRequest("POST", "http://www.example.org/foo", strings.NewReader(largeBody))
+	r.Header.Set("Content-Type", "application/json")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: only the size is logged
+	a.Equal(fmt.Sprintf("(exceeds %d bytes)", RequestBodyMaxSize), mapFromBuffer(b)["request_body"])
+
+	// and the handler could still read the complete body
+	a.Equal(largeBody, handlerBody)
+}
+
+func Test_LogMiddleware_RequestBody_Redacted(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware capturing json, form and text bodies
+	CallBodyRedactedFields = []string{"password"}
+	defer func() { CallBodyRedactedFields = nil }()
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	}), WithRequestBody(func(int) bool { return true },
+		"application/json", "application/x-www-form-urlencoded", "text/plain"))
+
+	for contentType, test := range map[string]struct {
+		body   string
+		logged interface{}
+	}{
+		"application/json":                  {`{"user":"alice","password":"secret"}`, `{"password":"*****","user":"alice"}`},
+		"application/x-www-form-urlencoded": {`user=alice&password=secret`, `password=*****&user=alice`},
+		"text/plain":                        {`password=secret`, nil},
+	} {
+		// when: a request with the body is served
+		b.Reset()
+		r := httptest.NewRequest("POST", "http://www.example.org/login", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", contentType)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+
+		// then: the body is logged redacted or not at all
+		a.Equal(test.logged, mapFromBuffer(b)["request_body"], contentType)
+	}
+
+	// when: an invalid json body is served
+	b.Reset()
+	r := httptest.NewRequest("POST", "http://www.example.org/login", strings.NewReader(`{"password":"secret"`))
+	r.Header.Set("Content-Type", "application/json")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is not logged, as it can not be redacted
+	a.NotContains(mapFromBuffer(b), "request_body")
+}
+
+func Test_LogMiddleware_RequestBody_Status(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware capturing json bodies of 4xx responses
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithRequestBody(func(statusCode int) bool { return statusCode >= 400 }, "application/json"))
+
+	// when: a json request succeeds
+	r := httptest.NewRequest("POST", "http://www.example.org/foo", strings.NewReader(`{"name":"foo"}`))
+	r.Header.Set("Content-Type", "application/json")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the body is not logged
+	a.NotContains(mapFromBuffer(b), "request_body")
+
+	// when: a too large body is rejected
+	b.Reset()
+	RequestBodyMaxSize = 5
+	defer func() { RequestBodyMaxSize = 4096 }()
+	lm = NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(413) }),
+		WithRequestBody(func(statusCode int) bool { return statusCode >= 400 }, "application/json"))
+	r = httptest.NewRequest("POST", "http://www.example.org/foo", strings.NewReader(`{"name":"foo"}`))
+	r.Header.Set("Content-Type", "application/json")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: only the size is logged
+	a.Equal("(exceeds 5 bytes)", mapFromBuffer(b)["request_body"])
+}
+
+func Test_LogMiddleware_NestedCorrelationId(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and nested middlewares, the inner one with another correlation header
+	var handlerId string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerId = CorrelationIdFromContext(r.Context())
+	})
+	edge := NewLogMiddleware(NewLogMiddleware(NewLogMiddleware(handler, WithCorrelationHeader("X-Request-Id"))))
+
+	// when: a request without correlation id is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	edge.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: all middlewares log the same id
+	records := logRecordsFromBuffer(b)
+	a.Len(records, 3)
+	a.NotEmpty(handlerId)
+	for _, record := range records {
+		a.Equal(handlerId, record.CorrelationId)
+	}
+
+	// and it is only generated once
+	a.Equal(handlerId, EnsureCorrelationId(r))
+	a.Equal(handlerId, r.Header.Get(CorrelationIdHeader))
+}
+
+func Test_LogMiddleware_SlowRequestThreshold(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware with a slow request threshold
+	sleep := time.Duration(0)
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(sleep)
+	}), WithSlowRequestThreshold(10*time.Millisecond), WithErrorsOnly())
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: a fast request is served
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is not logged
+	a.Equal(0, b.Len())
+
+	// when: the handler sleeps past the threshold
+	sleep = 20 * time.Millisecond
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the request is logged as slow at warn level
+	data := map[string]interface{}{}
+	a.NoError(json.Unmarshal(b.Bytes(), &data))
+	a.Equal(true, data["slow"])
+	a.Equal("warning", data["level"])
+	a.Equal(float64(200), data["response_status"])
+}
+
+func Test_LogMiddleware_ErrorsOnly(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware logging only errors
+	statusCode := 200
+	var requestErr error
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestErr != nil {
+			SetRequestError(r, requestErr)
+		}
+		w.WriteHeader(statusCode)
+	}), WithErrorsOnly())
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: successful requests are served
+	for _, statusCode = range []int{200, 204, 302, 399} {
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: nothing is logged
+	a.Equal(0, b.Len())
+
+	// when: failed requests are served
+	for _, statusCode = range []int{400, 500} {
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: they are logged
+	records := logRecordsFromBuffer(b)
+	a.Len(records, 2)
+	a.Equal(400, records[0].ResponseStatus)
+	a.Equal(500, records[1].ResponseStatus)
+
+	// when: a successful request with an error is served
+	b.Reset()
+	statusCode = 200
+	requestErr = errors.New("degraded")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is logged
+	a.Equal("degraded", logRecordFromBuffer(b).Error)
+}
+
+func Test_LogMiddleware_LogType(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and two middlewares with different log types
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	gateway := NewLogMiddleware(handler, WithLogType("gateway_access"))
+	mesh := NewLogMiddleware(handler)
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: a request is served by the gateway
+	gateway.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the configured type is logged
+	a.Equal("gateway_access", logRecordFromBuffer(b).Type)
+
+	// when: a request is served by the mesh
+	b.Reset()
+	mesh.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the default type is logged
+	a.Equal("access", logRecordFromBuffer(b).Type)
+}
+
+func Test_LogMiddleware_CorrelationHeader(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware using the X-Request-Id header
+	var requestId string
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId = r.Header.Get("X-Request-Id")
+	}), WithCorrelationHeader("X-Request-Id"))
+
+	// when: a request with a request id is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("X-Request-Id", "request-123")
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is used as correlation id
+	a.Equal("request-123", requestId)
+	a.Equal("request-123", logRecordFromBuffer(b).CorrelationId)
+	a.Equal("", r.Header.Get(CorrelationIdHeader))
+
+	// when: a request without request id is served
+	b.Reset()
+	r, _ = http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: a generated id is set to the custom header
+	a.NotEmpty(requestId)
+	a.Equal(requestId, logRecordFromBuffer(b).CorrelationId)
+}
+
+func Test_LogMiddleware_FieldEnricher(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware enriching the user id out of the request context
+	type userKey struct{}
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*r = *r.WithContext(context.WithValue(r.Context(), userKey{}, "user-1"))
+	}), WithFieldEnricher(func(r *http.Request) logrus.Fields {
+		return logrus.Fields{"user_id": r.Context().Value(userKey{})}
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the enriched field is logged
+	a.Equal("user-1", mapFromBuffer(b)["user_id"])
+}
+
+func Test_LogMiddleware_ResponseHeaders(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a middleware capturing response headers
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("X-Other", "other")
+	}), WithResponseHeaders("cache-control", "ETag", "X-Missing"))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the configured headers are logged
+	a.Equal(map[string]interface{}{
+		"Cache-Control": "max-age=60",
+		"Etag":          `"abc"`,
+	}, mapFromBuffer(b)["response_headers"])
+}
@@ -2,163 +2,458 @@ package logging
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+// Logger is the entry for application logs. It is created once and reconfigured in place by Set,
+// so that it is safe for use concurrent to Set. Its entries are formatted and written by the package,
+// so the output and formatter have to be changed with SetOutput and SetTypeFormatter instead of the setters of logrus.
 var Logger *logrus.Entry
 var logger *logrus.Logger
 
-// The of cookies which should not be logged
+// setMu serializes the changes of the configuration of the logger and guards its hooks,
+// which can not be read safely from the logger itself
+var setMu sync.Mutex
+
+// logOutput is installed once as formatter and output of the logger. It is guarded by its own lock,
+// so that entries written past the logger, e.g. with a forced level, are not interleaved with the entries of the logger
+// and a reconfiguration replaces the formatter and the output at once.
+var logOutput = &loggerOutput{}
+
+// The of cookies which should not be logged, use SetAccessLogCookiesBlacklist to change it while serving requests
 var AccessLogCookiesBlacklist []string
+
+// If not empty, only the listed cookies are logged.
+// The blacklist still applies, so cookies contained in both lists are not logged.
+var AccessLogCookiesWhitelist []string
+
+// List of cookies which are logged with a masked value, so that only their presence is visible
+var MaskedCookies []string
+
+// Switch for the cookie logging, if disabled the cookies of the request are not even parsed
 var AccessLogWithCookies = true
 
+// If set, the cookies are only logged for responses outside of the 2xx range
+var AccessLogCookiesOnlyOnFailure = false
+
+// List of request headers which should be logged
+var LogRequestHeaders []string
+
+// List of logged request headers whose values should be anonymized
+var RedactedHeaders []string
+
 var LifecycleEnvVars = []string{"BUILD_NUMBER", "BUILD_HASH", "BUILD_DATE"}
 
-// List of query params that should be anonymized
+// Mapping from the name of a lifecycle env var to the name of its log field.
+// Env vars without a mapping are logged with their lowercased name.
+var LifecycleEnvVarFieldNames = map[string]string{}
+
+// EnvType is the type, a lifecycle env var is parsed into
+type EnvType int
+
+const (
+	EnvString EnvType = iota
+	EnvInt
+	EnvBool
+)
+
+// Mapping from the name of a lifecycle env var to its type.
+// Env vars without a mapping or with an unparsable value are logged as string.
+var LifecycleEnvVarTypes = map[string]EnvType{}
+
+var processStart = time.Now()
+
+// Now returns the current time, it is used to compute the durations of access and call logs
+var Now = time.Now
+
+// If set, access and call logs contain the duration in microseconds as duration_us,
+// in addition to the duration in milliseconds
+var LogDurationMicros = false
+
+// If set, the host field of access logs contains the host without its port,
+// which is always logged as a separate port field. By default the host field keeps the combined host:port value.
+var AccessLogSplitHostPort = false
+
+// Mapping from the status code of access and call logs to their log level
+var LevelForStatus = DefaultLevelForStatus
+
+// Hook which returns additional fields of access logs for the remote ip, e.g. geo_country or asn out of a GeoIP database
+var RemoteIpEnricher func(ip string) logrus.Fields
+
+// Formatter of the human readable message of access logs, the structured fields are not affected by it.
+// The status code is 0 for hijacked connections, as their status is unknown.
+var AccessMessageFormatter = DefaultAccessMessage
+
+// Log levels for discrete status codes, which take precedence over LevelForStatus
+var StatusLevelOverrides = map[int]logrus.Level{}
+
+// List of query params that should be anonymized, use SetAnonymizedQueryParams to change it while serving requests
 var AnonymizedQueryParams []string
 
+// listsMu guards the lists, which can be changed while serving requests
+var listsMu sync.RWMutex
+
+// SetAnonymizedQueryParams replaces the AnonymizedQueryParams, it is safe for use concurrent to logging.
+func SetAnonymizedQueryParams(params []string) {
+	params = append([]string(nil), params...)
+	listsMu.Lock()
+	defer listsMu.Unlock()
+	AnonymizedQueryParams = params
+}
+
+// SetAccessLogCookiesBlacklist replaces the AccessLogCookiesBlacklist, it is safe for use concurrent to logging.
+func SetAccessLogCookiesBlacklist(cookies []string) {
+	cookies = append([]string(nil), cookies...)
+	listsMu.Lock()
+	defer listsMu.Unlock()
+	AccessLogCookiesBlacklist = cookies
+}
+
+func anonymizedQueryParams() []string {
+	listsMu.RLock()
+	defer listsMu.RUnlock()
+	return AnonymizedQueryParams
+}
+
+func accessLogCookiesBlacklist() []string {
+	listsMu.RLock()
+	defer listsMu.RUnlock()
+	return AccessLogCookiesBlacklist
+}
+
+// List of patterns for query params that should be anonymized, in addition to AnonymizedQueryParams
+var AnonymizedQueryParamPatterns []*regexp.Regexp
+
+// PathSegmentRule decides, whether the segment with the given index of the path segments is anonymized.
+// The index of the first segment after the leading slash is 0.
+type PathSegmentRule func(segments []string, index int) bool
+
+// List of rules for path segments that should be anonymized, e.g. ids or e-mail addresses in the path
+var AnonymizedPathSegments []PathSegmentRule
+
+// SegmentAtIndex anonymizes the path segment with the given index, e.g. 1 for the id in /users/123
+func SegmentAtIndex(index int) PathSegmentRule {
+	return func(segments []string, i int) bool {
+		return i == index
+	}
+}
+
+// SegmentAfter anonymizes the path segments following a segment with the given name,
+// e.g. the e-mail address in /users/by-email/alice@example.com
+func SegmentAfter(name string) PathSegmentRule {
+	return func(segments []string, i int) bool {
+		return i > 0 && segments[i-1] == name
+	}
+}
+
+// SegmentMatching anonymizes the path segments matching the given pattern
+func SegmentMatching(pattern *regexp.Regexp) PathSegmentRule {
+	return func(segments []string, i int) bool {
+		return pattern.MatchString(segments[i])
+	}
+}
+
+// Maximum length of logged urls, longer ones are truncated. A value <= 0 disables the limit.
+var MaxLoggedUrlLength = 0
+
+// Number of trusted proxies in front of the service, each appending the address it received to X-Forwarded-For.
+// The remote ip is taken from the entry appended by the outermost trusted proxy. With the default of 0, the left-most entry is used.
+// If set, the host and the scheme of access logs are also taken from the X-Forwarded-Host and X-Forwarded-Proto headers of the trusted proxies.
+var TrustedProxyCount = 0
+
+// If set, the query params are logged in the order of the request instead of sorted by name
+var PreserveQueryParamOrder = false
+
+// If set, the fragment of the url is logged, which is usually only known for outgoing calls
+var LogUrlFragment = false
+
+// Maximum number of query params which are logged.
+// Only the first params of the request are parsed, further params are omitted and replaced by a marker.
+// In the sorted order, the logged params are sorted. A value <= 0 disables the limit.
+var MaxLoggedQueryParams = 0
+
+// If set, the @timestamp field is omitted, e.g. for log shippers adding their own timestamp.
+// It takes effect on the next call of Set.
+var DisableTimestampField = false
+
 func init() {
+	logger = logrus.New()
+	logger.SetOutput(logOutput)
+	logger.SetFormatter(logOutput)
+	Logger = logger.WithFields(logrus.Fields{
+		"@version": "1",
+		"type":     "log",
+	})
 	_ = Set("info", false)
 }
 
-// Set creates a new Logger with the matching specification
+// Set configures the Logger with the matching specification
 func Set(level string, textLogging bool) error {
-	l, err := logrus.ParseLevel(level)
+	return SetWithOutput(level, textLogging, os.Stderr)
+}
+
+// SetWithOutput configures the Logger with the matching specification, which writes to the given output.
+// Use io.MultiWriter to write to multiple outputs. The hooks and type formatters of the Logger are removed.
+// It is safe for use concurrent to logging, the output and the formatter are replaced at once.
+func SetWithOutput(level string, textLogging bool, out io.Writer) error {
+	lvl, err := logrus.ParseLevel(level)
 	if err != nil {
 		return err
 	}
 
-	logger = logrus.New()
-	logger.SetLevel(l)
-
 	fm := logrus.FieldMap{
 		logrus.FieldKeyTime: "@timestamp",
 		logrus.FieldKeyMsg:  "message",
 	}
 
+	var f logrus.Formatter
 	if textLogging {
-		logger.Formatter = &logrus.TextFormatter{
-			TimestampFormat: time.RFC3339Nano,
-			FieldMap:        fm,
+		f = &logrus.TextFormatter{
+			TimestampFormat:  time.RFC3339Nano,
+			DisableTimestamp: DisableTimestampField,
+			FieldMap:         fm,
 		}
 	} else {
-		logger.Formatter = &logrus.JSONFormatter{
-			TimestampFormat: time.RFC3339Nano,
-			FieldMap:        fm,
+		f = &logrus.JSONFormatter{
+			TimestampFormat:  time.RFC3339Nano,
+			DisableTimestamp: DisableTimestampField,
+			FieldMap:         fm,
 		}
 	}
 
-	Logger = logger.WithFields(logrus.Fields{
-		"@version": "1",
-		"type":     "log",
-	})
+	setMu.Lock()
+	defer setMu.Unlock()
+	logger.ReplaceHooks(make(logrus.LevelHooks))
+	logger.SetLevel(lvl)
+	logOutput.set(out, f)
 	return nil
 }
 
+// SetOutput replaces the output of the Logger and returns the previous one.
+// It is safe for use concurrent to logging.
+func SetOutput(out io.Writer) io.Writer {
+	logOutput.mu.Lock()
+	defer logOutput.mu.Unlock()
+	previous := logOutput.out
+	logOutput.out = out
+	return previous
+}
+
+// currentFormatter returns the formatter of the Logger
+func currentFormatter() logrus.Formatter {
+	_, formatter := logOutput.current()
+	return formatter
+}
+
+// loggerOutput formats the entries and writes them to the output, both can be replaced at once.
+// An entry is written to the output, which was configured when the entry was formatted.
+// The logger formats and writes an entry under its own lock, so that no other entry of the logger is formatted in between.
+type loggerOutput struct {
+	mu        sync.Mutex
+	out       io.Writer
+	formatter logrus.Formatter
+	formatted io.Writer
+}
+
+// Format formats the entry with the configured formatter
+func (o *loggerOutput) Format(entry *logrus.Entry) ([]byte, error) {
+	out, formatter := o.current()
+	o.mu.Lock()
+	o.formatted = out
+	o.mu.Unlock()
+	return formatter.Format(entry)
+}
+
+// Write writes the last formatted entry of the logger to the output, which was configured when it was formatted
+func (o *loggerOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.formatted.Write(p)
+}
+
+// writeTo writes a serialized entry to the given output under the lock of the logger output
+func (o *loggerOutput) writeTo(out io.Writer, p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return out.Write(p)
+}
+
+// current returns the configured output and formatter
+func (o *loggerOutput) current() (io.Writer, logrus.Formatter) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.out, o.formatter
+}
+
+// set replaces the output and the formatter at once
+func (o *loggerOutput) set(out io.Writer, formatter logrus.Formatter) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.out, o.formatter = out, formatter
+}
+
+// GetLogger returns the Logger
+func GetLogger() *logrus.Entry {
+	return Logger
+}
+
 // Access logs an access entry with call duration and status code
 func Access(r *http.Request, start time.Time, statusCode int) {
-	e := access(r, start, statusCode, nil)
+	logAccess(r, NewAccessRecord(r, start, statusCode, nil))
+}
 
-	var msg string
-	if len(r.URL.RawQuery) == 0 {
-		msg = fmt.Sprintf("%v ->%v %v", statusCode, r.Method, r.URL.Path)
-	} else {
-		msg = fmt.Sprintf("%v ->%v %v?...", statusCode, r.Method, r.URL.Path)
+// AccessError logs an error while accessing
+func AccessError(r *http.Request, start time.Time, err error) {
+	logAccessError(r, NewAccessRecord(r, start, 0, err))
+}
+
+func logAccess(r *http.Request, record AccessRecord) {
+	e := GetLogger().WithFields(record.Fields())
+	msg := AccessMessageFormatter(r, record.ResponseStatus)
+	level := levelForStatus(record.ResponseStatus)
+
+	// the status of a hijacked connection is unknown
+	if record.Hijacked {
+		level = logrus.InfoLevel
 	}
 
-	if statusCode >= 200 && statusCode <= 399 {
-		e.Info(msg)
-	} else if statusCode >= 400 && statusCode <= 499 {
-		e.Warn(msg)
-	} else {
-		e.Error(msg)
+	if record.Slow && level > logrus.WarnLevel {
+		level = logrus.WarnLevel
 	}
-}
 
-// AccessError logs an error while accessing
-func AccessError(r *http.Request, start time.Time, err error) {
-	e := access(r, start, 0, err)
-	e.Errorf("ERROR ->%v %v", r.Method, r.URL.Path)
+	if lc := getLogContext(r.Context()); lc != nil {
+		if requestLevel, ok := lc.requestLogLevel(); ok {
+			logForced(e, requestLevel, msg)
+			return
+		}
+	}
+
+	e.Log(level, msg)
 }
 
-func access(r *http.Request, start time.Time, statusCode int, err error) *logrus.Entry {
-	fields := logrus.Fields{
-		"type":       "access",
-		"remote_ip":  getRemoteIp(r),
-		"host":       r.Host,
-		"url":        buildFullPath(r),
-		"method":     r.Method,
-		"proto":      r.Proto,
-		"duration":   time.Since(start).Nanoseconds() / 1000000,
-		"User_Agent": r.Header.Get("User-Agent"),
+// logForced writes the entry with the given level, even if the level is not enabled in the Logger.
+// It is formatted and written like the entries of the Logger, whose level is unchanged.
+func logForced(e *logrus.Entry, level logrus.Level, msg string) {
+	if e.Logger.IsLevelEnabled(level) {
+		e.Log(level, msg)
+		return
 	}
 
-	if statusCode != 0 {
-		fields["response_status"] = statusCode
+	out, formatter := logOutput.current()
+	entry := e.WithTime(time.Now())
+	entry.Level = level
+	entry.Message = msg
+	// hooks formatting the entry use the same formatter, without changing the state of the logger output
+	entry.Logger = &logrus.Logger{Formatter: formatter, Level: level}
+
+	setMu.Lock()
+	if err := e.Logger.Hooks.Fire(level, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fire hook: %v\n", err)
 	}
+	setMu.Unlock()
 
+	serialized, err := formatter.Format(entry)
 	if err != nil {
-		fields[logrus.ErrorKey] = err.Error()
+		fmt.Fprintf(os.Stderr, "Failed to obtain reader, %v\n", err)
+		return
 	}
+	if _, err := logOutput.writeTo(out, serialized); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to log, %v\n", err)
+	}
+}
 
-	setCorrelationIds(fields, r.Header)
-
-	cookies := map[string]string{}
-	for _, c := range r.Cookies() {
-		if !contains(AccessLogCookiesBlacklist, c.Name) {
-			cookies[c.Name] = c.Value
-		}
+// DefaultAccessMessage formats the message of access logs like "200 ->GET /foo?...", omitting the query.
+// Hijacked connections are logged like "HIJACKED ->GET /foo".
+func DefaultAccessMessage(r *http.Request, statusCode int) string {
+	if statusCode == 0 {
+		return accessMessage(r, "HIJACKED")
 	}
-	if AccessLogWithCookies && len(cookies) > 0 {
-		fields["cookies"] = cookies
+	return accessMessage(r, fmt.Sprint(statusCode))
+}
+
+func accessMessage(r *http.Request, status string) string {
+	if len(r.URL.RawQuery) == 0 {
+		return fmt.Sprintf("%v ->%v %v", status, r.Method, loggedPath(r))
 	}
+	return fmt.Sprintf("%v ->%v %v?...", status, r.Method, loggedPath(r))
+}
 
-	return Logger.WithFields(fields)
+func logAccessError(r *http.Request, record AccessRecord) {
+	e := GetLogger().WithFields(record.Fields())
+	e.Errorf("ERROR ->%v %v", r.Method, loggedPath(r))
 }
 
 // Call logs the result of an outgoing call
 func Call(r *http.Request, resp *http.Response, start time.Time, err error) {
-	fields := logrus.Fields{
-		"type":     "call",
-		"host":     r.Host,
-		"url":      buildFullPath(r),
-		"full_url": buildFullUrl(r),
-		"method":   r.Method,
-		"duration": time.Since(start).Nanoseconds() / 1000000,
+	CallWithAttempt(r, resp, start, err, 0)
+}
+
+// CallWithAttempt logs the result of an attempt of a retried outgoing call.
+// Attempts are counted from 1 and all attempts of the request share the call id in the CallIdHeader,
+// which has to be set with EnsureCallId before the first attempt is sent. An attempt of 0 is logged like by Call.
+func CallWithAttempt(r *http.Request, resp *http.Response, start time.Time, err error, attempt int) {
+	record := NewCallRecord(r, resp, start, err)
+	if attempt > 0 {
+		record.Attempt = attempt
+		record.CallId = r.Header.Get(CallIdHeader)
 	}
+	logCall(r, record)
+}
 
-	setCorrelationIds(fields, r.Header)
+// CallContext logs the result of an outgoing call like Call.
+// Correlation ids, which are not set in the headers of the request, are taken from the given context.
+func CallContext(ctx context.Context, r *http.Request, resp *http.Response, start time.Time, err error) {
+	record := NewCallRecord(r, resp, start, err)
+	if record.CorrelationId == "" {
+		record.CorrelationId = CorrelationIdFromContext(ctx)
+	}
+	if record.UserCorrelationId == "" {
+		record.UserCorrelationId = UserCorrelationIdFromContext(ctx)
+	}
+	logCall(r, record)
+}
 
-	if err != nil {
-		fields[logrus.ErrorKey] = err.Error()
-		Logger.WithFields(fields).Error(err)
+func logCall(r *http.Request, record CallRecord) {
+	e := GetLogger().WithFields(record.Fields())
+
+	if record.Error != nil {
+		if CallErrorLogInterval > 0 {
+			key := fmt.Sprintf("%v %v%v %v", record.Method, record.Host, r.URL.Path, record.Error)
+			allowed, suppressed := callErrors.allow(key, Now(), CallErrorLogInterval, e, record.Error)
+			if !allowed {
+				return
+			}
+			if suppressed > 0 {
+				e = e.WithField("suppressed_count", suppressed)
+			}
+		}
+		e.Error(record.Error)
 		return
 	}
 
-	if resp != nil {
-		fields["response_status"] = resp.StatusCode
-		fields["content_type"] = resp.Header.Get("Content-Type")
-		e := Logger.WithFields(fields)
-		msg := fmt.Sprintf("%v %v-> %v", resp.StatusCode, r.Method, buildFullUrl(r))
-
-		if resp.StatusCode >= 200 && resp.StatusCode <= 399 {
-			e.Info(msg)
-		} else if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-			e.Warn(msg)
-		} else {
-			e.Error(msg)
-		}
+	if record.ResponseStatus != 0 {
+		msg := fmt.Sprintf("%v %v-> %v", record.ResponseStatus, record.Method, record.FullURL)
+		e.Log(levelForStatus(record.ResponseStatus), msg)
 		return
 	}
 
-	Logger.WithFields(fields).Warn("call, but no response given")
+	e.Warn("call, but no response given")
 }
 
 // Cacheinfo logs the hit information a accessing a ressource
@@ -169,7 +464,7 @@ func Cacheinfo(url string, hit bool) {
 	} else {
 		msg = fmt.Sprintf("cache miss: %v", url)
 	}
-	Logger.WithFields(
+	GetLogger().WithFields(
 		logrus.Fields{
 			"type": "cacheinfo",
 			"url":  url,
@@ -185,7 +480,40 @@ func Application(h http.Header) *logrus.Entry {
 		"type": "application",
 	}
 	setCorrelationIds(fields, h)
-	return Logger.WithFields(fields)
+	return GetLogger().WithFields(fields)
+}
+
+// ApplicationWithContext returns a log entry for application logs like Application,
+// additionally pre-filled with the trace and span id of the OpenTelemetry span in the context.
+func ApplicationWithContext(ctx context.Context, h http.Header) *logrus.Entry {
+	fields := logrus.Fields{
+		"type": "application",
+	}
+	setCorrelationIds(fields, h)
+	traceId, spanId := getTraceIds(ctx)
+	setTraceIds(fields, traceId, spanId)
+	return GetLogger().WithFields(fields)
+}
+
+// AuthDecision logs an authentication/authorization decision (e.g. allow or deny)
+// with the correlation ids out of the supplied request.
+// The fields should contain the standard keys "subject", "resource" and "policy" where applicable.
+// Decisions other than "allow" are logged with level warning.
+func AuthDecision(h http.Header, decision string, fields logrus.Fields) {
+	f := logrus.Fields{}
+	for k, v := range fields {
+		f[k] = v
+	}
+	f["type"] = "authz"
+	f["decision"] = decision
+	setCorrelationIds(f, h)
+
+	msg := fmt.Sprintf("authz decision: %v", decision)
+	if decision == "allow" {
+		GetLogger().WithFields(f).Info(msg)
+	} else {
+		GetLogger().WithFields(f).Warn(msg)
+	}
 }
 
 // LifecycleStart logs the start of an application
@@ -202,13 +530,21 @@ func LifecycleStart(appName string, args interface{}) {
 	}
 	fields["type"] = "lifecycle"
 	fields["event"] = "start"
-	for _, env := range LifecycleEnvVars {
-		if os.Getenv(env) != "" {
-			fields[strings.ToLower(env)] = os.Getenv(env)
-		}
+	fields["go_version"] = runtime.Version()
+	if version := moduleVersion(); version != "" {
+		fields["module_version"] = version
 	}
+	setLifecycleEnvVars(fields)
+
+	GetLogger().WithFields(fields).Infof("starting application: %v", appName)
+}
 
-	Logger.WithFields(fields).Infof("starting application: %v", appName)
+// moduleVersion returns the version of the main module, as embedded by the go tool
+func moduleVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return ""
 }
 
 // LifecycleStop logs the stop of an application
@@ -222,18 +558,124 @@ func LifecycleStop(appName string, signal os.Signal, err error) {
 	}
 
 	if os.Getenv("BUILD_NUMBER") != "" {
-		fields["build_number"] = os.Getenv("BUILD_NUMBER")
+		fields[lifecycleEnvVarFieldName("BUILD_NUMBER")] = os.Getenv("BUILD_NUMBER")
 	}
 
 	if err != nil {
-		Logger.WithFields(fields).
+		GetLogger().WithFields(fields).
 			WithError(err).
 			Errorf("stopping application: %v (%v)", appName, err)
 	} else {
-		Logger.WithFields(fields).Infof("stopping application: %v (%v)", appName, signal)
+		GetLogger().WithFields(fields).Infof("stopping application: %v (%v)", appName, signal)
+	}
+}
+
+// LifecycleRestart logs the restart of an application, e.g. because of a reload of its configuration
+func LifecycleRestart(appName string, reason string) {
+	fields := logrus.Fields{
+		"type":   "lifecycle",
+		"event":  "restart",
+		"reason": reason,
+	}
+	setLifecycleEnvVars(fields)
+
+	GetLogger().WithFields(fields).Infof("restarting application: %v (%v)", appName, reason)
+}
+
+// LifecycleHeartbeat logs that an application is still running,
+// with its uptime in seconds and the number of goroutines.
+func LifecycleHeartbeat(appName string) {
+	fields := logrus.Fields{
+		"type":       "lifecycle",
+		"event":      "heartbeat",
+		"uptime":     int64(time.Since(processStart).Seconds()),
+		"goroutines": runtime.NumGoroutine(),
+	}
+	setLifecycleEnvVars(fields)
+
+	GetLogger().WithFields(fields).Infof("application running: %v", appName)
+}
+
+// StartLifecycleHeartbeat logs a heartbeat in the given interval,
+// until the returned stop function is called.
+// The stop function returns after a pending heartbeat is written.
+// With an interval <= 0, no heartbeat is logged and the returned stop function does nothing.
+func StartLifecycleHeartbeat(appName string, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				LifecycleHeartbeat(appName)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+		<-stopped
 	}
 }
 
+func setLifecycleEnvVars(fields logrus.Fields) {
+	for _, env := range LifecycleEnvVars {
+		if os.Getenv(env) != "" {
+			fields[lifecycleEnvVarFieldName(env)] = lifecycleEnvVarValue(env, os.Getenv(env))
+		}
+	}
+}
+
+func lifecycleEnvVarValue(env, value string) interface{} {
+	switch LifecycleEnvVarTypes[env] {
+	case EnvInt:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case EnvBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func lifecycleEnvVarFieldName(env string) string {
+	if name, exists := LifecycleEnvVarFieldNames[env]; exists {
+		return name
+	}
+	return strings.ToLower(env)
+}
+
+// DefaultLevelForStatus maps 2xx and 3xx status codes to info, 4xx to warning and all others to error
+func DefaultLevelForStatus(statusCode int) logrus.Level {
+	if statusCode >= 200 && statusCode <= 399 {
+		return logrus.InfoLevel
+	} else if statusCode >= 400 && statusCode <= 499 {
+		return logrus.WarnLevel
+	}
+	return logrus.ErrorLevel
+}
+
+func levelForStatus(statusCode int) logrus.Level {
+	if level, exists := StatusLevelOverrides[statusCode]; exists {
+		return level
+	}
+	return LevelForStatus(statusCode)
+}
+
 func getRemoteIp(r *http.Request) string {
 	if r.Header.Get("X-Cluster-Client-Ip") != "" {
 		return r.Header.Get("X-Cluster-Client-Ip")
@@ -241,50 +683,262 @@ func getRemoteIp(r *http.Request) string {
 	if r.Header.Get("X-Real-Ip") != "" {
 		return r.Header.Get("X-Real-Ip")
 	}
-	return strings.Split(r.RemoteAddr, ":")[0]
+	if ip := getForwardedForIp(r.Header.Get("X-Forwarded-For")); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// the remote addr has no port
+		return strings.TrimSuffix(strings.TrimPrefix(r.RemoteAddr, "["), "]")
+	}
+	return host
 }
 
-func setCorrelationIds(fields logrus.Fields, h http.Header) {
-	correlationId := GetCorrelationId(h)
-	if correlationId != "" {
-		fields["correlation_id"] = correlationId
+// getHost returns the public host of the request, which is set in the X-Forwarded-Host header by trusted proxies.
+// Each proxy appends the host it received, so the entry of the outermost trusted proxy is used.
+func getHost(r *http.Request) string {
+	forwardedHost := r.Header.Get("X-Forwarded-Host")
+	if TrustedProxyCount <= 0 || forwardedHost == "" {
+		return r.Host
 	}
-	userCorrelationId := GetUserCorrelationId(h)
-	if userCorrelationId != "" {
-		fields["user_correlation_id"] = userCorrelationId
+	hosts := strings.Split(forwardedHost, ",")
+	i := len(hosts) - TrustedProxyCount
+	if i < 0 {
+		i = 0
 	}
+	return strings.TrimSpace(hosts[i])
+}
+
+// getForwardedForIp returns the client ip out of an X-Forwarded-For chain.
+// Like in getHost, the entry appended by the outermost of the TrustedProxyCount proxies is used.
+func getForwardedForIp(forwardedFor string) string {
+	if forwardedFor == "" {
+		return ""
+	}
+	hops := strings.Split(forwardedFor, ",")
+	i := 0
+	if TrustedProxyCount > 0 {
+		i = len(hops) - TrustedProxyCount
+		if i < 0 {
+			i = 0
+		}
+	}
+	return strings.TrimSpace(hops[i])
+}
+
+func setCorrelationIds(fields logrus.Fields, h http.Header) {
+	setRecordCorrelationIds(fields, GetCorrelationId(h), GetUserCorrelationId(h))
+	setPropagatedIds(fields, getPropagatedIds(h))
 }
 
 func buildFullPath(r *http.Request) string {
-	queryParams := make(url.Values, len(r.URL.Query()))
+	return truncateUrl(fullPath(r))
+}
+
+func fullPath(r *http.Request) string {
+	var queryString string
+	if PreserveQueryParamOrder {
+		queryString = orderedQueryString(r.URL.RawQuery)
+	} else {
+		queryString = sortedQueryString(r.URL.RawQuery)
+	}
+
+	path := loggedPath(r)
+	if queryString != "" {
+		path = fmt.Sprintf("%s?%s", path, queryString)
+	}
+	if LogUrlFragment && r.URL.Fragment != "" {
+		path = fmt.Sprintf("%s#%s", path, r.URL.Fragment)
+	}
+	return path
+}
+
+// loggedPath returns the path of the request with the AnonymizedPathSegments masked
+func loggedPath(r *http.Request) string {
+	// the raw path retains encoded characters like %2F, which are lost in the decoded path
+	path := r.URL.Path
+	if r.URL.RawPath != "" {
+		path = r.URL.EscapedPath()
+	}
+	return anonymizePath(path)
+}
 
-	for key, value := range r.URL.Query() {
-		if contains(AnonymizedQueryParams, key) {
-			queryParams[key] = []string{"*****"}
+// anonymizePath masks the path segments matched by the AnonymizedPathSegments
+func anonymizePath(path string) string {
+	if len(AnonymizedPathSegments) == 0 {
+		return path
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	anonymized := make([]string, len(segments))
+	for i, segment := range segments {
+		anonymized[i] = segment
+		if segment == "" {
+			continue
+		}
+		for _, rule := range AnonymizedPathSegments {
+			if rule(segments, i) {
+				anonymized[i] = "*****"
+				break
+			}
+		}
+	}
+	if strings.HasPrefix(path, "/") {
+		return "/" + strings.Join(anonymized, "/")
+	}
+	return strings.Join(anonymized, "/")
+}
+
+// sortedQueryString returns the unescaped query string sorted by the names of the params,
+// substituting the values of anonymized params
+func sortedQueryString(rawQuery string) string {
+	pairs, omitted := queryPairs(rawQuery)
+	query := make(url.Values, len(pairs))
+	for _, pair := range pairs {
+		if isAnonymizedQueryParam(pair.key) {
+			query[pair.key] = []string{"*****"}
 		} else {
-			queryParams[key] = value
+			query[pair.key] = append(query[pair.key], pair.value)
 		}
 	}
 
-	queryString, _ := url.QueryUnescape(queryParams.Encode())
-	if queryString != "" {
-		return fmt.Sprintf("%s?%s", r.URL.Path, queryString)
-	} else {
-		return fmt.Sprintf("%s", r.URL.Path)
+	queryString, _ := url.QueryUnescape(query.Encode())
+	if omitted > 0 {
+		queryString = fmt.Sprintf("%s&...(%d more)", queryString, omitted)
 	}
+	return queryString
+}
+
+// orderedQueryString returns the unescaped query string in the order of the raw query,
+// substituting the values of anonymized params in place
+func orderedQueryString(rawQuery string) string {
+	pairs, omitted := queryPairs(rawQuery)
+	params := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		value := pair.value
+		if isAnonymizedQueryParam(pair.key) {
+			value = "*****"
+		}
+		params = append(params, pair.key+"="+value)
+	}
+
+	queryString := strings.Join(params, "&")
+	if omitted > 0 {
+		queryString = fmt.Sprintf("%s&...(%d more)", queryString, omitted)
+	}
+	return queryString
+}
+
+type queryPair struct {
+	key   string
+	value string
+}
+
+// queryPairs returns the unescaped params of the raw query in their order, limited to MaxLoggedQueryParams,
+// and the number of omitted params. The omitted params are only counted, so that floods of params are cheap.
+// Params which can not be unescaped are skipped.
+func queryPairs(rawQuery string) ([]queryPair, int) {
+	var pairs []queryPair
+	omitted := 0
+	for rawQuery != "" {
+		var pair string
+		if i := strings.IndexByte(rawQuery, '&'); i >= 0 {
+			pair, rawQuery = rawQuery[:i], rawQuery[i+1:]
+		} else {
+			pair, rawQuery = rawQuery, ""
+		}
+		if pair == "" {
+			continue
+		}
+		if MaxLoggedQueryParams > 0 && len(pairs) >= MaxLoggedQueryParams {
+			omitted++
+			continue
+		}
 
+		kv := strings.SplitN(pair, "=", 2)
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			continue
+		}
+		value := ""
+		if len(kv) == 2 {
+			if value, err = url.QueryUnescape(kv[1]); err != nil {
+				continue
+			}
+		}
+		pairs = append(pairs, queryPair{key: key, value: value})
+	}
+	return pairs, omitted
 }
 
 func buildFullUrl(r *http.Request) string {
+	return buildFullUrlWithPath(r, fullPath(r))
+}
+
+// buildFullUrlWithPath returns the full url of the request with the given logged path
+func buildFullUrlWithPath(r *http.Request, path string) string {
 	var buffer bytes.Buffer
-	buffer.WriteString(r.URL.Scheme + "://")
-	buffer.WriteString(r.URL.Hostname())
-	if r.URL.Port() != "" {
-		buffer.WriteString(":" + r.URL.Port())
+	buffer.WriteString(getScheme(r) + "://")
+	if r.URL.Host != "" {
+		buffer.WriteString(r.URL.Hostname())
+		if r.URL.Port() != "" {
+			buffer.WriteString(":" + r.URL.Port())
+		}
+	} else {
+		// server side requests have no host in the url
+		buffer.WriteString(r.Host)
+	}
+	buffer.WriteString(path)
+
+	return truncateUrl(buffer.String())
+}
+
+// getScheme returns the scheme of the request, which is often not set in the url of server side requests.
+// It is detected from the TLS state and the X-Forwarded-Proto header of trusted TLS-terminating proxies.
+// Like in getHost, the header is only used with TrustedProxyCount, as it can be sent by any client.
+func getScheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
 	}
-	buffer.WriteString(buildFullPath(r))
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); TrustedProxyCount > 0 && proto != "" {
+		protos := strings.Split(proto, ",")
+		i := len(protos) - TrustedProxyCount
+		if i < 0 {
+			i = 0
+		}
+		return strings.ToLower(strings.TrimSpace(protos[i]))
+	}
+	return "http"
+}
+
+// truncateUrl shortens the url to MaxLoggedUrlLength, marking it as truncated
+func truncateUrl(u string) string {
+	if MaxLoggedUrlLength <= 0 || len(u) <= MaxLoggedUrlLength {
+		return u
+	}
+	end := MaxLoggedUrlLength
+	for end > 0 && !utf8.RuneStart(u[end]) {
+		end--
+	}
+	return u[:end] + "…(truncated)"
+}
+
+func isAnonymizedQueryParam(key string) bool {
+	if contains(anonymizedQueryParams(), key) {
+		return true
+	}
+	for _, pattern := range AnonymizedQueryParamPatterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
 
-	return buffer.String()
+func isSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode <= 299
 }
 
 func contains(s []string, e string) bool {
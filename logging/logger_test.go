@@ -2,12 +2,21 @@ package logging
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -40,9 +49,9 @@ func Test_Logger_Set(t *testing.T) {
 	// given: an error logger in text format
 	Set("error", true)
 	defer Set("info", false)
-	logger.Formatter.(*logrus.TextFormatter).DisableColors = true
+	currentFormatter().(*logrus.TextFormatter).DisableColors = true
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// when: I log something
 	Logger.Info("should be ignored ..")
@@ -53,12 +62,289 @@ func Test_Logger_Set(t *testing.T) {
 	a.Regexp(`^@timestamp="(.*?)" level\=error message\=oops @version=1 foo\=bar.* type=log`, b.String())
 }
 
+func Test_Logger_SetWithOutput(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger writing to a buffer
+	b := bytes.NewBuffer(nil)
+	err := SetWithOutput("info", false, b)
+	defer Set("info", false)
+	a.NoError(err)
+
+	// when: I log something
+	Logger.Info("hello")
+
+	// then: it is written to the buffer
+	data := mapFromBuffer(b)
+	a.Equal("hello", data["message"])
+	a.Equal("log", data["type"])
+}
+
+func Test_Logger_SetConcurrentToAccess(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+	entry := Logger
+
+	// given: requests which are logged concurrently through the accessor and the Logger variable
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					Access(r, time.Now(), 200)
+					Call(r, &http.Response{StatusCode: 500}, time.Now(), nil)
+					GetLogger().Info("hello")
+					Logger.Warn("hello")
+				}
+			}
+		}()
+	}
+
+	// when: the logger is reconfigured at the same time
+	for i := 0; i < 100; i++ {
+		a.NoError(SetWithOutput("info", i%2 == 0, ioutil.Discard))
+		SetTypeFormatter("access", &logrus.JSONFormatter{})
+		SetOutput(ioutil.Discard)
+		a.NoError(SetWithOutput("debug", false, ioutil.Discard))
+	}
+	close(done)
+	wg.Wait()
+
+	// then: the race detector finds no data race and the Logger is reconfigured in place
+	a.True(entry == Logger)
+	a.True(entry == GetLogger())
+	a.Equal(logrus.DebugLevel, Logger.Logger.GetLevel())
+}
+
+func Test_Logger_SetConcurrentToLogging_Atomic(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+
+	// given: entries which are logged concurrently
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					Logger.Info("hello")
+				}
+			}
+		}()
+	}
+
+	// when: the logger is switched between a json and a slow text output at the same time
+	jsonOutput, textOutput := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	for i := 0; i < 20; i++ {
+		a.NoError(SetWithOutput("info", false, jsonOutput))
+		time.Sleep(time.Millisecond)
+		a.NoError(SetWithOutput("info", true, textOutput))
+		SetTypeFormatter("log", slowFormatter{&logrus.TextFormatter{FieldMap: logrus.FieldMap{logrus.FieldKeyTime: "@timestamp"}}})
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	// then: each entry is written with the formatter of its output
+	a.NoError(SetWithOutput("info", false, ioutil.Discard))
+	for _, line := range strings.Split(jsonOutput.String(), "\n") {
+		a.True(line == "" || strings.HasPrefix(line, "{"), line)
+	}
+	for _, line := range strings.Split(textOutput.String(), "\n") {
+		a.True(line == "" || strings.HasPrefix(line, "@timestamp="), line)
+	}
+}
+
+type slowFormatter struct {
+	logrus.Formatter
+}
+
+func (f slowFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	time.Sleep(100 * time.Microsecond)
+	return f.Formatter.Format(entry)
+}
+
+func Test_Logger_SetOutput(t *testing.T) {
+	a := assert.New(t)
+	defer Set("info", false)
+
+	// given: a logger writing to a buffer
+	b := bytes.NewBuffer(nil)
+	a.NoError(SetWithOutput("info", false, b))
+
+	// when: the output is replaced
+	b2 := bytes.NewBuffer(nil)
+	previous := SetOutput(b2)
+	Logger.Info("hello")
+
+	// then: the previous output is returned and the entry is written to the new one
+	a.True(previous == b)
+	a.Equal(0, b.Len())
+	a.Contains(b2.String(), "hello")
+}
+
+func Test_Logger_DisableTimestampField(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger without timestamps
+	DisableTimestampField = true
+	b := bytes.NewBuffer(nil)
+	a.NoError(SetWithOutput("info", false, b))
+	defer func() {
+		DisableTimestampField = false
+		Set("info", false)
+	}()
+
+	// when: an access is logged
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	Access(r, time.Now(), 200)
+
+	// then: the timestamp is omitted
+	data := mapFromBuffer(b)
+	a.Equal("access", data["type"])
+	a.NotContains(data, "@timestamp")
+}
+
+func Test_Logger_SetWithOutput_InvalidLevel(t *testing.T) {
+	a := assert.New(t)
+
+	// when: an invalid level is given
+	err := SetWithOutput("verbose", false, bytes.NewBuffer(nil))
+
+	// then: an error is returned
+	a.Error(err)
+}
+
+func Test_Logger_CallWithAttempt(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a request which is retried with a call id set before the first attempt
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	id := EnsureCallId(r)
+	a.NotEmpty(id)
+	a.Equal(id, r.Header.Get(CallIdHeader))
+	a.Equal(id, EnsureCallId(r))
+
+	// when: two attempts are logged
+	headers := r.Header.Clone()
+	CallWithAttempt(r, nil, time.Now(), errors.New("connection refused"), 1)
+	CallWithAttempt(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil, 2)
+
+	// then: the request is not modified by logging
+	a.Equal(headers, r.Header)
+
+	// and: both share the call id and have their attempt
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		data := map[string]interface{}{}
+		a.NoError(json.Unmarshal([]byte(line), &data))
+		records = append(records, data)
+	}
+	a.Len(records, 2)
+	a.Equal(1.0, records[0]["attempt"])
+	a.Equal(2.0, records[1]["attempt"])
+	a.NotEmpty(records[0]["call_id"])
+	a.Equal(id, records[0]["call_id"])
+	a.Equal(id, records[1]["call_id"])
+
+	// when: a call is logged for a request with a given call id
+	b.Reset()
+	r.Header.Set(CallIdHeader, "call-123")
+	CallWithAttempt(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil, 1)
+
+	// then: the call id is used
+	data := mapFromBuffer(b)
+	a.Equal("call-123", data["call_id"])
+
+	// when: a call is logged without an attempt
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: no attempt fields are logged
+	data = mapFromBuffer(b)
+	a.NotContains(data, "attempt")
+	a.NotContains(data, "call_id")
+}
+
+func Test_Logger_CallContext(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a context with correlation ids
+	ctx := ContextWithCorrelationId(context.Background(), "correlation-123")
+	ctx = ContextWithUserCorrelationId(ctx, "user-correlation-123")
+
+	// and a request without correlation headers
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: the call is logged
+	CallContext(ctx, r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the ids are taken from the context
+	data := logRecordFromBuffer(b)
+	a.Equal("correlation-123", data.CorrelationId)
+	a.Equal("user-correlation-123", data.UserCorrelationId)
+
+	// when: the request has a correlation header
+	b.Reset()
+	r.Header.Set(CorrelationIdHeader, "header-123")
+	CallContext(ctx, r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the header is preferred
+	a.Equal("header-123", logRecordFromBuffer(b).CorrelationId)
+}
+
+func Test_Logger_Call_UserAgent(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an outgoing request with a user agent
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set("User-Agent", "my-service/1.2.3")
+
+	// when: the call is logged
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the user agent is logged
+	a.Equal("my-service/1.2.3", logRecordFromBuffer(b).UserAgent)
+
+	// when: a request without user agent is logged
+	b.Reset()
+	r.Header.Del("User-Agent")
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: it is omitted
+	a.NotContains(mapFromBuffer(b), "User_Agent")
+}
+
 func Test_Logger_Call(t *testing.T) {
 	a := assert.New(t)
 
 	// given a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 	AccessLogCookiesBlacklist = []string{"ignore", "user_id"}
 	// and a request
 	r, _ := http.NewRequest("GET", "http://www.example.org/foo?q=bar", nil)
@@ -162,7 +448,7 @@ func Test_Logger_Access(t *testing.T) {
 
 	// given a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 	AccessLogCookiesBlacklist = []string{"ignore", "user_id"}
 
 	// Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/51.0.2704.84 Safari/537.36
@@ -239,7 +525,7 @@ func Test_Logger_Access(t *testing.T) {
 	// when: We log a request with access with AccessLogWithCookies false
 	b.Reset()
 	AccessLogWithCookies = false
-	defer func() { AnonymizedQueryParams = nil }()
+	defer func() { AccessLogWithCookies = true }()
 	start = time.Now().Add(-1 * time.Second)
 	Access(r, start, 201)
 
@@ -264,12 +550,213 @@ func Test_Logger_Access(t *testing.T) {
 
 }
 
+func Test_Logger_Access_CookiesWhitelist(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	defer func(blacklist []string) { AccessLogCookiesBlacklist = blacklist }(AccessLogCookiesBlacklist)
+	AccessLogCookiesBlacklist = nil
+	AccessLogCookiesWhitelist = []string{"foo", "session"}
+	defer func() { AccessLogCookiesWhitelist = nil }()
+
+	// and a request with cookies
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header = http.Header{
+		"Cookie": {"foo=bar; session=secret; other=value"},
+	}
+
+	// when: the request is logged
+	Access(r, time.Now(), 200)
+
+	// then: only the whitelisted cookies are logged
+	a.Equal(map[string]string{"foo": "bar", "session": "secret"}, logRecordFromBuffer(b).Cookies)
+
+	// when: a whitelisted cookie is also blacklisted or masked
+	b.Reset()
+	AccessLogCookiesBlacklist = []string{"session"}
+	MaskedCookies = []string{"foo"}
+	defer func() { MaskedCookies = nil }()
+	Access(r, time.Now(), 200)
+
+	// then: the blacklist and the masking still apply
+	a.Equal(map[string]string{"foo": "*****"}, logRecordFromBuffer(b).Cookies)
+}
+
+func Test_Logger_Access_CookiesDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger without cookie logging
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	AccessLogWithCookies = false
+	defer func() { AccessLogWithCookies = true }()
+
+	// and a request with cookies
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header = http.Header{
+		"Cookie": {"foo=bar; session=secret"},
+	}
+
+	// when: the request is logged
+	Access(r, time.Now(), 500)
+
+	// then: no cookies field is emitted
+	a.NotContains(mapFromBuffer(b), "cookies")
+}
+
+func Test_Logger_Access_CookiesOnlyOnFailure(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	AccessLogCookiesOnlyOnFailure = true
+	defer func() { AccessLogCookiesOnlyOnFailure = false }()
+
+	// and a request with cookies
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header = http.Header{
+		"Cookie": {"foo=bar;"},
+	}
+
+	// when a successful request is logged
+	Access(r, time.Now(), 200)
+
+	// then: the cookies are omitted
+	data := logRecordFromBuffer(b)
+	a.Equal(map[string]string(nil), data.Cookies)
+
+	// when a failed request is logged
+	b.Reset()
+	Access(r, time.Now(), 401)
+
+	// then: the cookies are contained
+	data = logRecordFromBuffer(b)
+	a.Equal(map[string]string{"foo": "bar"}, data.Cookies)
+}
+
+func Test_Logger_Access_MaskedCookies(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	defer func(blacklist []string) { AccessLogCookiesBlacklist = blacklist }(AccessLogCookiesBlacklist)
+	AccessLogCookiesBlacklist = []string{"ignore"}
+	MaskedCookies = []string{"session"}
+	defer func() { MaskedCookies = nil }()
+
+	// and a request with a blacklisted, a masked and a normal cookie
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header = http.Header{
+		"Cookie": {"ignore=me; session=secret; foo=bar;"},
+	}
+
+	// when the request is logged
+	Access(r, time.Now(), 200)
+
+	// then: the blacklisted cookie is omitted and the masked one has no value
+	data := logRecordFromBuffer(b)
+	a.Equal(map[string]string{"session": "*****", "foo": "bar"}, data.Cookies)
+}
+
+func Test_Logger_Access_Headers(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	LogRequestHeaders = []string{"Accept", "authorization", "X-Api-Key", "X-Missing"}
+	RedactedHeaders = []string{"Authorization", "x-api-key"}
+	defer func() {
+		LogRequestHeaders = nil
+		RedactedHeaders = nil
+	}()
+
+	// and a request
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header = http.Header{
+		"Accept":        {"text/html", "application/json"},
+		"Authorization": {"Bearer secret"},
+		"X-Api-Key":     {"secret"},
+		"X-Other":       {"other"},
+	}
+
+	// when: We log a request with access
+	Access(r, time.Now(), 200)
+
+	// then: the logged headers are contained and the redacted ones are masked
+	data := mapFromBuffer(b)
+	a.Equal(map[string]interface{}{
+		"Accept":        "text/html, application/json",
+		"Authorization": "*****",
+		"X-Api-Key":     "*****",
+	}, data["headers"])
+}
+
+func Test_Logger_Access_RequestSize(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a request with a body
+	r, _ := http.NewRequest("POST", "http://www.example.org/foo", strings.NewReader("hello"))
+
+	// when: We log a request with access
+	Access(r, time.Now(), 200)
+
+	// then: the request size is logged
+	a.Equal(5.0, mapFromBuffer(b)["request_size"])
+
+	// when the request size is unknown
+	b.Reset()
+	r.ContentLength = -1
+	Access(r, time.Now(), 200)
+
+	// then: the request size is omitted
+	a.NotContains(mapFromBuffer(b), "request_size")
+}
+
+func Test_Logger_Now(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a fake clock
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	Now = func() time.Time {
+		return start.Add(1500 * time.Millisecond)
+	}
+	defer func() { Now = time.Now }()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when: an access is logged
+	Access(r, start, 200)
+
+	// then: the exact duration is logged
+	a.Equal(1500, logRecordFromBuffer(b).Duration)
+
+	// when: a call is logged
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, start, nil)
+
+	// then: the exact duration is logged
+	a.Equal(1500, logRecordFromBuffer(b).Duration)
+}
+
 func Test_Logger_Access_ErrorCases(t *testing.T) {
 	a := assert.New(t)
 
 	// given a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and a request
 	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
@@ -301,6 +788,139 @@ func Test_Logger_Access_ErrorCases(t *testing.T) {
 	a.NoError(err, "timestamp should be printed as RFĆ3339Nano but was not")
 }
 
+func Test_Logger_LevelForStatus(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a custom mapping
+	LevelForStatus = func(statusCode int) logrus.Level {
+		if statusCode == 404 {
+			return logrus.InfoLevel
+		}
+		return DefaultLevelForStatus(statusCode)
+	}
+	defer func() { LevelForStatus = DefaultLevelForStatus }()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when an access with 404 is logged
+	Access(r, time.Now(), 404)
+
+	// then: the custom level is used
+	a.Equal("info", logRecordFromBuffer(b).Level)
+
+	// when a call with 404 is logged
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 404, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the custom level is used
+	a.Equal("info", logRecordFromBuffer(b).Level)
+
+	// when an access with 429 is logged
+	b.Reset()
+	Access(r, time.Now(), 429)
+
+	// then: the default level is used
+	a.Equal("warning", logRecordFromBuffer(b).Level)
+}
+
+func Test_Logger_AccessMessageFormatter(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a custom message formatter
+	AccessMessageFormatter = func(r *http.Request, statusCode int) string {
+		return fmt.Sprintf("%v %v status=%v", r.Method, r.URL.Path, statusCode)
+	}
+	defer func() { AccessMessageFormatter = DefaultAccessMessage }()
+
+	// when: a request is logged
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo?q=bar", nil)
+	Access(r, time.Now(), 201)
+
+	// then: the message is formatted by it
+	data := logRecordFromBuffer(b)
+	a.Equal("GET /foo status=201", data.Message)
+	a.Equal(201, data.ResponseStatus)
+	a.Equal("/foo?q=bar", data.URL)
+
+	// and: the default formats the status, method and path
+	a.Equal("201 ->GET /foo?...", DefaultAccessMessage(r, 201))
+
+	// and: hijacked connections are logged without a status
+	a.Equal("HIJACKED ->GET /foo?...", DefaultAccessMessage(r, 0))
+}
+
+func Test_Logger_Access_AnonymizedPathSegments_Message(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an anonymized path segment
+	AnonymizedPathSegments = []PathSegmentRule{SegmentAfter("by-email")}
+	defer func() { AnonymizedPathSegments = nil }()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/users/by-email/alice@example.com", nil)
+
+	// when: the request is logged
+	Access(r, time.Now(), 200)
+
+	// then: the segment is masked in the url and the message
+	data := logRecordFromBuffer(b)
+	a.Equal("/users/by-email/*****", data.URL)
+	a.Equal("200 ->GET /users/by-email/*****", data.Message)
+
+	// when: an error is logged
+	b.Reset()
+	AccessError(r, time.Now(), errors.New("oops"))
+
+	// then: the segment is masked in the message
+	a.Equal("ERROR ->GET /users/by-email/*****", logRecordFromBuffer(b).Message)
+}
+
+func Test_Logger_StatusLevelOverrides(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and overrides for discrete status codes
+	StatusLevelOverrides = map[int]logrus.Level{
+		499: logrus.InfoLevel,
+		429: logrus.ErrorLevel,
+	}
+	defer func() { StatusLevelOverrides = map[int]logrus.Level{} }()
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// when accesses with overridden status codes are logged
+	Access(r, time.Now(), 499)
+	Access(r, time.Now(), 429)
+	Access(r, time.Now(), 404)
+
+	// then: the overrides are used
+	records := logRecordsFromBuffer(b)
+	a.Equal("info", records[0].Level)
+	a.Equal("error", records[1].Level)
+	a.Equal("warning", records[2].Level)
+
+	// when a call with an overridden status code is logged
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 499, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the override is used
+	a.Equal("info", logRecordFromBuffer(b).Level)
+}
+
 func Test_Logger_Application(t *testing.T) {
 	a := assert.New(t)
 
@@ -316,12 +936,52 @@ func Test_Logger_Application(t *testing.T) {
 	a.Equal("correlation-123", entry.Data["correlation_id"])
 }
 
+func Test_Logger_AuthDecision(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a request header
+	header := http.Header{
+		CorrelationIdHeader: {"correlation-123"},
+	}
+
+	// when a deny decision is logged
+	AuthDecision(header, "deny", logrus.Fields{
+		"subject":  "user-1",
+		"resource": "/admin",
+		"policy":   "admins-only",
+	})
+
+	// then: it is logged
+	data := mapFromBuffer(b)
+	a.Equal("warning", data["level"])
+	a.Equal("authz", data["type"])
+	a.Equal("deny", data["decision"])
+	a.Equal("authz decision: deny", data["message"])
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("user-1", data["subject"])
+	a.Equal("/admin", data["resource"])
+	a.Equal("admins-only", data["policy"])
+
+	// when an allow decision is logged
+	b.Reset()
+	AuthDecision(header, "allow", nil)
+
+	// then: it is logged as info
+	data = mapFromBuffer(b)
+	a.Equal("info", data["level"])
+	a.Equal("allow", data["decision"])
+}
+
 func Test_Logger_LifecycleStart(t *testing.T) {
 	a := assert.New(t)
 
 	// given a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and
 	someArguments := struct {
@@ -346,17 +1006,76 @@ func Test_Logger_LifecycleStart(t *testing.T) {
 	a.Equal("bar", data["Foo"])
 	a.Equal(42.0, data["Number"])
 	a.Equal("b666", data["build_number"])
+	a.Equal(runtime.Version(), data["go_version"])
+	a.NotEmpty(data["go_version"])
 	_, err := time.Parse(time.RFC3339Nano, data["@timestamp"].(string))
 	a.NoError(err, "timestamp should be printed as RFĆ3339Nano but was not")
 
 }
 
+func Test_Logger_LifecycleStart_EnvVarFieldNames(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a field name mapping for the Build Hash
+	LifecycleEnvVarFieldNames = map[string]string{"BUILD_HASH": "buildHash"}
+	defer func() { LifecycleEnvVarFieldNames = map[string]string{} }()
+	os.Setenv("BUILD_NUMBER", "b666")
+	os.Setenv("BUILD_HASH", "abc123")
+	defer os.Unsetenv("BUILD_HASH")
+
+	// when a LifecycleStart is logged
+	LifecycleStart("my-app", struct{}{})
+
+	// then: the mapped name is used and the others are lowercased
+	data := mapFromBuffer(b)
+	a.Equal("abc123", data["buildHash"])
+	a.NotContains(data, "build_hash")
+	a.Equal("b666", data["build_number"])
+}
+
+func Test_Logger_LifecycleStart_EnvVarTypes(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and typed env vars
+	defer func(vars []string) { LifecycleEnvVars = vars }(LifecycleEnvVars)
+	LifecycleEnvVars = []string{"REPLICA_COUNT", "FEATURE_ENABLED", "MAX_CONNECTIONS"}
+	LifecycleEnvVarTypes = map[string]EnvType{
+		"REPLICA_COUNT":   EnvInt,
+		"FEATURE_ENABLED": EnvBool,
+		"MAX_CONNECTIONS": EnvInt,
+	}
+	defer func() { LifecycleEnvVarTypes = map[string]EnvType{} }()
+	os.Setenv("REPLICA_COUNT", "3")
+	defer os.Unsetenv("REPLICA_COUNT")
+	os.Setenv("FEATURE_ENABLED", "true")
+	defer os.Unsetenv("FEATURE_ENABLED")
+	os.Setenv("MAX_CONNECTIONS", "unlimited")
+	defer os.Unsetenv("MAX_CONNECTIONS")
+
+	// when a LifecycleStart is logged
+	LifecycleStart("my-app", struct{}{})
+
+	// then: the values are parsed, falling back to string
+	data := mapFromBuffer(b)
+	a.Equal(3.0, data["replica_count"])
+	a.Equal(true, data["feature_enabled"])
+	a.Equal("unlimited", data["max_connections"])
+}
+
 func Test_Logger_LifecycleStop(t *testing.T) {
 	a := assert.New(t)
 
 	// given a logger
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// and an Environment Variable with the Build Number is set
 	os.Setenv("BUILD_NUMBER", "b666")
@@ -376,6 +1095,91 @@ func Test_Logger_LifecycleStop(t *testing.T) {
 	a.NoError(err, "timestamp should be printed as RFĆ3339Nano but was not")
 }
 
+func Test_Logger_LifecycleRestart(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an Environment Variable with the Build Number is set
+	os.Setenv("BUILD_NUMBER", "b666")
+
+	// when a LifecycleRestart is logged
+	LifecycleRestart("my-app", "config reload")
+
+	// then: it is logged
+	data := mapFromBuffer(b)
+	a.Equal("info", data["level"])
+	a.Equal("restarting application: my-app (config reload)", data["message"])
+	a.Equal("lifecycle", data["type"])
+	a.Equal("restart", data["event"])
+	a.Equal("config reload", data["reason"])
+	a.Equal("b666", data["build_number"])
+}
+
+func Test_Logger_LifecycleHeartbeat(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and an Environment Variable with the Build Number is set
+	os.Setenv("BUILD_NUMBER", "b666")
+
+	// when a LifecycleHeartbeat is logged
+	LifecycleHeartbeat("my-app")
+
+	// then: it is logged
+	data := mapFromBuffer(b)
+	a.Equal("info", data["level"])
+	a.Equal("application running: my-app", data["message"])
+	a.Equal("lifecycle", data["type"])
+	a.Equal("heartbeat", data["event"])
+	a.Equal("b666", data["build_number"])
+	a.Contains(data, "uptime")
+	a.Contains(data, "goroutines")
+}
+
+func Test_Logger_StartLifecycleHeartbeat(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := &syncBuffer{}
+	SetOutput(b)
+	defer SetOutput(bytes.NewBuffer(nil))
+
+	// when the heartbeat is started
+	stop := StartLifecycleHeartbeat("my-app", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	stop()
+
+	// then: heartbeats are logged
+	a.Contains(b.String(), `"event":"heartbeat"`)
+}
+
+func Test_Logger_StartLifecycleHeartbeat_NonPositiveInterval(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := &syncBuffer{}
+	SetOutput(b)
+	defer SetOutput(bytes.NewBuffer(nil))
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		// when the heartbeat is started without a positive interval
+		var stop func()
+		a.NotPanics(func() { stop = StartLifecycleHeartbeat("my-app", interval) }, interval)
+		time.Sleep(5 * time.Millisecond)
+		stop()
+
+		// then: no heartbeat is logged
+		a.Equal("", b.String(), interval)
+	}
+}
+
 func Test_Logger_Cacheinfo(t *testing.T) {
 	a := assert.New(t)
 
@@ -383,7 +1187,7 @@ func Test_Logger_Cacheinfo(t *testing.T) {
 	Set("debug", false)
 	defer Set("info", false)
 	b := bytes.NewBuffer(nil)
-	logger.Out = b
+	SetOutput(b)
 
 	// when a positive cachinfo is logged
 	Cacheinfo("/foo", true)
@@ -403,6 +1207,38 @@ func Test_Logger_Cacheinfo(t *testing.T) {
 	a.Equal("cache miss: /foo", data["message"])
 }
 
+func Test_Logger_GetHost(t *testing.T) {
+	a := assert.New(t)
+
+	// given a request without forwarded host
+	r, _ := http.NewRequest("GET", "http://internal.svc/foo", nil)
+
+	// then: the host of the request is used
+	a.Equal("internal.svc", getHost(r))
+
+	// when: the forwarded host is set, but no proxy is trusted
+	r.Header.Set("X-Forwarded-Host", "www.example.org")
+
+	// then: it is ignored
+	a.Equal("internal.svc", getHost(r))
+
+	// when: a proxy is trusted
+	TrustedProxyCount = 1
+	defer func() { TrustedProxyCount = 0 }()
+
+	// then: the forwarded host is used
+	a.Equal("www.example.org", getHost(r))
+
+	// and: of a chain the entry of the outermost trusted proxy is used
+	r.Header.Set("X-Forwarded-Host", "spoofed.example.com, www.example.org")
+	a.Equal("www.example.org", getHost(r))
+	TrustedProxyCount = 2
+	r.Header.Set("X-Forwarded-Host", "spoofed.example.com, www.example.org, gateway.internal")
+	a.Equal("www.example.org", getHost(r))
+	TrustedProxyCount = 5
+	a.Equal("spoofed.example.com", getHost(r))
+}
+
 func Test_Logger_GetRemoteIp1(t *testing.T) {
 	a := assert.New(t)
 	req, _ := http.NewRequest("GET", "test.com", nil)
@@ -427,6 +1263,58 @@ func Test_Logger_GetRemoteIp3(t *testing.T) {
 	a.Equal("1234", ret)
 }
 
+func Test_Logger_GetRemoteIp_IPv6(t *testing.T) {
+	a := assert.New(t)
+	req, _ := http.NewRequest("GET", "test.com", nil)
+
+	req.RemoteAddr = "[2001:db8::1]:443"
+	a.Equal("2001:db8::1", getRemoteIp(req))
+
+	req.RemoteAddr = "[2001:db8::1]"
+	a.Equal("2001:db8::1", getRemoteIp(req))
+
+	req.RemoteAddr = "2001:db8::1"
+	a.Equal("2001:db8::1", getRemoteIp(req))
+
+	req.RemoteAddr = "127.0.0.1"
+	a.Equal("127.0.0.1", getRemoteIp(req))
+}
+
+func Test_Logger_GetRemoteIp_ForwardedFor(t *testing.T) {
+	a := assert.New(t)
+	req, _ := http.NewRequest("GET", "test.com", nil)
+	req.RemoteAddr = "10.0.0.3:80"
+
+	// the left-most entry is used by default
+	req.Header.Set("X-Forwarded-For", " 1.1.1.1 , 10.0.0.1, 10.0.0.2")
+	a.Equal("1.1.1.1", getRemoteIp(req))
+
+	// the entry appended by the outermost trusted proxy is used
+	TrustedProxyCount = 1
+	defer func() { TrustedProxyCount = 0 }()
+	a.Equal("10.0.0.2", getRemoteIp(req))
+
+	TrustedProxyCount = 2
+	a.Equal("10.0.0.1", getRemoteIp(req))
+
+	// a spoofed entry sent by the client is skipped
+	TrustedProxyCount = 1
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	a.Equal("1.2.3.4", getRemoteIp(req))
+	req.Header.Set("X-Forwarded-For", " 1.1.1.1 , 10.0.0.1, 10.0.0.2")
+
+	TrustedProxyCount = 5
+	a.Equal("1.1.1.1", getRemoteIp(req))
+
+	// X-Real-Ip takes precedence
+	req.Header.Set("X-Real-Ip", "1234")
+	a.Equal("1234", getRemoteIp(req))
+
+	// without the header, the remote addr is used
+	req.Header = http.Header{}
+	a.Equal("10.0.0.3", getRemoteIp(req))
+}
+
 func Test_buildFullPath(t *testing.T) {
 	AnonymizedQueryParams = []string{"q1", "q3"}
 	defer func() { AnonymizedQueryParams = nil }()
@@ -439,6 +1327,209 @@ func Test_buildFullPath(t *testing.T) {
 	assert.NotContains(t, path, "q3=")
 }
 
+func Test_SetAnonymizedQueryParams_Concurrent(t *testing.T) {
+	a := assert.New(t)
+	SetOutput(ioutil.Discard)
+	defer SetAnonymizedQueryParams(nil)
+	defer func(blacklist []string) { SetAccessLogCookiesBlacklist(blacklist) }(AccessLogCookiesBlacklist)
+
+	// given: requests which are logged concurrently
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo?token=secret&q=1", nil)
+	r.Header.Set("Cookie", "session=secret")
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					buildFullPath(r)
+					Access(r, time.Now(), 500)
+				}
+			}
+		}()
+	}
+
+	// when: the lists are changed at the same time
+	for i := 0; i < 100; i++ {
+		SetAnonymizedQueryParams([]string{"token"})
+		SetAccessLogCookiesBlacklist([]string{"session"})
+	}
+	close(done)
+	wg.Wait()
+
+	// then: the race detector finds no data race and the lists are applied
+	a.Equal("/foo?q=1&token=*****", buildFullPath(r))
+	a.Nil(NewAccessRecord(r, time.Now(), 500, nil).Cookies)
+}
+
+func Test_buildFullPath_AnonymizedQueryParamPatterns(t *testing.T) {
+	AnonymizedQueryParams = []string{"password"}
+	AnonymizedQueryParamPatterns = []*regexp.Regexp{regexp.MustCompile(`^token_\d+$`)}
+	defer func() {
+		AnonymizedQueryParams = nil
+		AnonymizedQueryParamPatterns = nil
+	}()
+
+	req, _ := http.NewRequest("GET", "test.com?password=a&token_123=b&token_abc=c&q=d", nil)
+	path := buildFullPath(req)
+
+	assert.Equal(t, "test.com?password=*****&q=d&token_123=*****&token_abc=c", path)
+}
+
+func Test_buildFullPath_AnonymizedPathSegments_Index(t *testing.T) {
+	AnonymizedPathSegments = []PathSegmentRule{SegmentAtIndex(1)}
+	defer func() { AnonymizedPathSegments = nil }()
+
+	req, _ := http.NewRequest("GET", "http://www.example.org/users/123/orders?q=1", nil)
+	assert.Equal(t, "/users/*****/orders?q=1", buildFullPath(req))
+
+	req, _ = http.NewRequest("GET", "http://www.example.org/users", nil)
+	assert.Equal(t, "/users", buildFullPath(req))
+}
+
+func Test_buildFullPath_AnonymizedPathSegments_Pattern(t *testing.T) {
+	AnonymizedPathSegments = []PathSegmentRule{
+		SegmentAfter("by-email"),
+		SegmentMatching(regexp.MustCompile(`^\d{4,}$`)),
+	}
+	defer func() { AnonymizedPathSegments = nil }()
+
+	req, _ := http.NewRequest("GET", "http://www.example.org/users/by-email/alice@example.com/orders/12345/", nil)
+	assert.Equal(t, "/users/by-email/*****/orders/*****/", buildFullPath(req))
+
+	req, _ = http.NewRequest("GET", "http://www.example.org/users/by-email/", nil)
+	assert.Equal(t, "/users/by-email/", buildFullPath(req))
+}
+
+func Test_buildFullPath_PreserveQueryParamOrder(t *testing.T) {
+	PreserveQueryParamOrder = true
+	AnonymizedQueryParams = []string{"token"}
+	defer func() {
+		PreserveQueryParamOrder = false
+		AnonymizedQueryParams = nil
+	}()
+
+	req, _ := http.NewRequest("GET", "test.com?z=1&token=secret&a=b%20c&flag&z=2", nil)
+	path := buildFullPath(req)
+
+	assert.Equal(t, "test.com?z=1&token=*****&a=b c&flag=&z=2", path)
+
+	MaxLoggedQueryParams = 2
+	defer func() { MaxLoggedQueryParams = 0 }()
+	assert.Equal(t, "test.com?z=1&token=*****&...(3 more)", buildFullPath(req))
+}
+
+func Test_buildFullPath_MaxLoggedQueryParams(t *testing.T) {
+	MaxLoggedQueryParams = 2
+	defer func() { MaxLoggedQueryParams = 0 }()
+
+	req, _ := http.NewRequest("GET", "test.com?a=1&b=2&c=3&d=4", nil)
+	path := buildFullPath(req)
+
+	assert.Equal(t, "test.com?a=1&b=2&...(2 more)", path)
+
+	// only the first params are parsed and sorted, the invalid escaping of further params is not noticed
+	req, _ = http.NewRequest("GET", "test.com?z=1&a=2&z=3&b=%zz&&c=4", nil)
+	assert.Equal(t, "test.com?a=2&z=1&...(3 more)", buildFullPath(req))
+}
+
+func Test_buildFullPath_RawPath(t *testing.T) {
+	a := assert.New(t)
+
+	// an encoded path segment is retained
+	req, _ := http.NewRequest("GET", "http://www.example.org/files/a%2Fb;version=2?q=1", nil)
+	a.Equal("/files/a%2Fb;version=2?q=1", buildFullPath(req))
+	a.Equal("http://www.example.org/files/a%2Fb;version=2?q=1", buildFullUrl(req))
+
+	// a path with the default encoding is logged decoded
+	req, _ = http.NewRequest("GET", "http://www.example.org/foo%20bar", nil)
+	a.Equal("/foo bar", buildFullPath(req))
+}
+
+func Test_buildFullPath_LogUrlFragment(t *testing.T) {
+	a := assert.New(t)
+
+	req, _ := http.NewRequest("GET", "http://www.example.org/foo?q=1#section", nil)
+
+	// the fragment is omitted by default
+	a.Equal("/foo?q=1", buildFullPath(req))
+
+	// and logged if enabled
+	LogUrlFragment = true
+	defer func() { LogUrlFragment = false }()
+	a.Equal("/foo?q=1#section", buildFullPath(req))
+}
+
+func Test_buildFullPath_MaxLoggedUrlLength(t *testing.T) {
+	a := assert.New(t)
+	MaxLoggedUrlLength = 20
+	defer func() { MaxLoggedUrlLength = 0 }()
+
+	// a long query is truncated
+	req, _ := http.NewRequest("GET", "http://www.example.org/foo?blob=aGVsbG8gd29ybGQgaGVsbG8gd29ybGQ", nil)
+	a.Equal("/foo?blob=aGVsbG8gd2…(truncated)", buildFullPath(req))
+	a.Equal("http://www.example.o…(truncated)", buildFullUrl(req))
+
+	// a short one is unchanged
+	req, _ = http.NewRequest("GET", "http://a.de/foo?q=1", nil)
+	a.Equal("/foo?q=1", buildFullPath(req))
+	a.Equal("http://a.de/foo?q=1", buildFullUrl(req))
+}
+
+func Test_buildFullUrl_Scheme(t *testing.T) {
+	a := assert.New(t)
+
+	// a plain server side request
+	req := httptest.NewRequest("GET", "/foo?q=1", nil)
+	a.Equal("http://example.com/foo?q=1", buildFullUrl(req))
+
+	// a request sending the header without a trusted proxy
+	req.Header.Set("X-Forwarded-Proto", "https")
+	a.Equal("http://example.com/foo?q=1", buildFullUrl(req))
+
+	// a request behind a trusted TLS-terminating proxy
+	TrustedProxyCount = 1
+	defer func() { TrustedProxyCount = 0 }()
+	a.Equal("https://example.com/foo?q=1", buildFullUrl(req))
+
+	// a request behind a trusted proxy, which appended the scheme sent by the client
+	req.Header.Set("X-Forwarded-Proto", "ftp, https")
+	a.Equal("https://example.com/foo?q=1", buildFullUrl(req))
+
+	// a TLS request
+	req = httptest.NewRequest("GET", "/foo", nil)
+	req.TLS = &tls.ConnectionState{}
+	a.Equal("https://example.com/foo", buildFullUrl(req))
+
+	// a client side request keeps its scheme
+	req, _ = http.NewRequest("GET", "http://www.example.org:8080/foo", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	a.Equal("http://www.example.org:8080/foo", buildFullUrl(req))
+}
+
+// syncBuffer is a buffer, which can be written concurrently
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
 func logRecordFromBuffer(b *bytes.Buffer) *logRecord {
 	data := &logRecord{}
 	err := json.Unmarshal(b.Bytes(), data)
@@ -448,6 +1539,19 @@ func logRecordFromBuffer(b *bytes.Buffer) *logRecord {
 	return data
 }
 
+func logRecordsFromBuffer(b *bytes.Buffer) []*logRecord {
+	var records []*logRecord
+	decoder := json.NewDecoder(bytes.NewReader(b.Bytes()))
+	for decoder.More() {
+		data := &logRecord{}
+		if err := decoder.Decode(data); err != nil {
+			panic(err.Error() + " " + b.String())
+		}
+		records = append(records, data)
+	}
+	return records
+}
+
 func mapFromBuffer(b *bytes.Buffer) map[string]interface{} {
 	data := map[string]interface{}{}
 	err := json.Unmarshal(b.Bytes(), &data)
@@ -0,0 +1,15 @@
+package logging
+
+import "time"
+
+// UnmatchedRoute is the path observed for requests without a route template,
+// so that the raw paths of e.g. scanners do not create a series each
+const UnmatchedRoute = "unmatched"
+
+// RequestObserver receives an observation for every request handled by the LogMiddleware,
+// e.g. to record Prometheus request duration histograms and status counters.
+type RequestObserver interface {
+	// Observe is called after the handler completed.
+	// The path is the route template, if set by ContextWithRoute, otherwise UnmatchedRoute.
+	Observe(method, path string, status int, duration time.Duration)
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type observation struct {
+	method   string
+	path     string
+	status   int
+	duration time.Duration
+}
+
+type fakeObserver struct {
+	observations []observation
+}
+
+func (o *fakeObserver) Observe(method, path string, status int, duration time.Duration) {
+	o.observations = append(o.observations, observation{method, path, status, duration})
+}
+
+func Test_LogMiddleware_Metrics(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a middleware with an observer
+	observer := &fakeObserver{}
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ContextWithRoute(r.Context(), "/users/{id}")
+		w.WriteHeader(201)
+	}), WithMetrics(observer))
+
+	// when: a request is served
+	r, _ := http.NewRequest("POST", "http://www.example.org/users/123", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is observed
+	a.Len(observer.observations, 1)
+	a.Equal("POST", observer.observations[0].method)
+	a.Equal("/users/{id}", observer.observations[0].path)
+	a.Equal(201, observer.observations[0].status)
+	a.True(observer.observations[0].duration >= 0)
+}
+
+func Test_LogMiddleware_Metrics_Unrouted(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and a middleware with an observer, whose handler sets no route
+	observer := &fakeObserver{}
+	lm := NewLogMiddleware(http.NotFoundHandler(), WithMetrics(observer))
+
+	// when: requests to different paths are served
+	for _, path := range []string{"/users/42", "/wp-login.php"} {
+		r, _ := http.NewRequest("GET", "http://www.example.org"+path, nil)
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// then: they are observed with the same path instead of the raw paths
+	a.Len(observer.observations, 2)
+	a.Equal(UnmatchedRoute, observer.observations[0].path)
+	a.Equal(UnmatchedRoute, observer.observations[1].path)
+	a.Equal(404, observer.observations[1].status)
+}
+
+func Test_LogMiddleware_Metrics_Panic(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	SetOutput(bytes.NewBuffer(nil))
+
+	// and handlers which panic before and after a status was written
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	panickingAfterStatus := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		panic("oops")
+	})
+
+	for name, test := range map[string]struct {
+		handler http.Handler
+		option  LogOption
+		status  int
+	}{
+		"no option":                  {panicking, func(*LogMiddleware) {}, 200},
+		"panic status":               {panicking, WithPanicStatus(503), 503},
+		"panic status after status":  {panickingAfterStatus, WithPanicStatus(503), 201},
+		"panic handler":              {panicking, WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) { w.WriteHeader(502) }), 502},
+		"panic handler after status": {panickingAfterStatus, WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) { w.WriteHeader(502) }), 201},
+		"panicking panic":            {panicking, WithPanicHandler(func(w http.ResponseWriter, r *http.Request, rec interface{}) { panic("again") }), 200},
+	} {
+		// when: the panicking request is served
+		observer := &fakeObserver{}
+		lm := NewLogMiddleware(test.handler, WithMetrics(observer), test.option)
+		r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+		rw := httptest.NewRecorder()
+		lm.ServeHTTP(rw, r)
+
+		// then: the status is the one of the response
+		a.Equal(test.status, rw.Code, name)
+
+		// and: it is observed and counted with it
+		a.Len(observer.observations, 1, name)
+		a.Equal(UnmatchedRoute, observer.observations[0].path, name)
+		a.Equal(rw.Code, observer.observations[0].status, name)
+		a.Equal(int64(1), lm.StatusCounts()[fmt.Sprintf("%dxx", rw.Code/100)], name)
+	}
+}
@@ -0,0 +1,24 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+)
+
+// RateLimit describes the rate limit which was applied to a request.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	Throttled bool
+}
+
+// SetRateLimit attaches the rate limit information to the request, so that it is included in the access log.
+// It has no effect if the request is not handled by the LogMiddleware.
+func SetRateLimit(r *http.Request, rateLimit RateLimit) {
+	if lc := getLogContext(r.Context()); lc != nil {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		lc.rateLimit = &rateLimit
+	}
+}
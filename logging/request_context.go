@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type logContextKey struct{}
+
+// logContext holds the information, which handlers attach to the access log of a request.
+// It is added to the request by the LogMiddleware, so that values set by inner handlers are visible to it.
+type logContext struct {
+	mu        sync.Mutex
+	rateLimit *RateLimit
+	route     string
+	fields    logrus.Fields
+	err       error
+	level     *logrus.Level
+	requestId string
+	entry     *logrus.Entry
+
+	correlationId          string
+	correlationIdGenerated bool
+}
+
+func withLogContext(r *http.Request) (*http.Request, *logContext) {
+	lc := &logContext{}
+	return r.WithContext(context.WithValue(r.Context(), logContextKey{}, lc)), lc
+}
+
+// getLogContext returns the log context of the LogMiddleware or nil, if the request is not handled by it
+func getLogContext(ctx context.Context) *logContext {
+	lc, _ := ctx.Value(logContextKey{}).(*logContext)
+	return lc
+}
+
+// AddLogField attaches a custom field to the access log of the request.
+// Multiple calls accumulate, fields of the access log itself can not be overwritten.
+// It has no effect if the request is not handled by the LogMiddleware.
+func AddLogField(r *http.Request, key string, value interface{}) {
+	lc := getLogContext(r.Context())
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.fields == nil {
+		lc.fields = logrus.Fields{}
+	}
+	lc.fields[key] = value
+}
+
+// customFields returns a copy of the fields added with AddLogField
+func (lc *logContext) customFields() logrus.Fields {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if len(lc.fields) == 0 {
+		return nil
+	}
+	fields := make(logrus.Fields, len(lc.fields))
+	for k, v := range lc.fields {
+		fields[k] = v
+	}
+	return fields
+}
+
+// SetRequestError attaches an application level error to the access log of the request,
+// e.g. a downstream failure which still results in a successful response.
+// It has no effect if the request is not handled by the LogMiddleware.
+func SetRequestError(r *http.Request, err error) {
+	if lc := getLogContext(r.Context()); lc != nil {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		lc.err = err
+	}
+}
+
+func (lc *logContext) requestError() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.err
+}
+
+// SetRequestLogLevel forces the access log of the request to be written with the given level,
+// regardless of the response status and the level of the Logger. This allows verbose logging of single requests.
+// It has no effect if the request is not handled by the LogMiddleware.
+func SetRequestLogLevel(r *http.Request, level logrus.Level) {
+	if lc := getLogContext(r.Context()); lc != nil {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		lc.level = &level
+	}
+}
+
+func (lc *logContext) requestLogLevel() (logrus.Level, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.level == nil {
+		return 0, false
+	}
+	return *lc.level, true
+}
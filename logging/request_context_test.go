@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AddLogField(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler adding fields during the handling
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AddLogField(r, "tenant_id", "t1")
+		AddLogField(r, "feature_flags", []string{"beta"})
+		AddLogField(r, "type", "overwritten")
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the fields are logged
+	data := mapFromBuffer(b)
+	a.Equal("t1", data["tenant_id"])
+	a.Equal([]interface{}{"beta"}, data["feature_flags"])
+	a.Equal("access", data["type"])
+}
+
+func Test_AddLogField_WithoutMiddleware(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// adding a field without the middleware has no effect
+	assert.NotPanics(t, func() { AddLogField(r, "tenant_id", "t1") })
+}
+
+func Test_SetRequestError(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler reporting a degraded result
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRequestError(r, errors.New("recommendations unavailable"))
+		w.WriteHeader(200)
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the error is logged with the successful status
+	data := logRecordFromBuffer(b)
+	a.Equal(200, data.ResponseStatus)
+	a.Equal("recommendations unavailable", data.Error)
+}
+
+func Test_SetRequestLogLevel(t *testing.T) {
+	a := assert.New(t)
+
+	// given an info logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a handler forcing debug logging
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRequestLogLevel(r, logrus.DebugLevel)
+	}))
+
+	// when: a successful request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is logged with the debug level
+	data := logRecordFromBuffer(b)
+	a.Equal("debug", data.Level)
+	a.Equal(200, data.ResponseStatus)
+	a.Equal("access", data.Type)
+
+	// and the level of the Logger is unchanged
+	a.Equal(logrus.InfoLevel, logger.GetLevel())
+
+	// when: a request is served without a forced level
+	b.Reset()
+	NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: it is logged with the status based level
+	a.Equal("info", logRecordFromBuffer(b).Level)
+}
+
+func Test_SetRequestLogLevel_ConcurrentToLogger(t *testing.T) {
+	a := assert.New(t)
+
+	// given an info logger writing to a buffer
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	defer SetOutput(bytes.NewBuffer(nil))
+
+	// when: forced entries and entries of the Logger are written concurrently
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logForced(GetLogger().WithField("forced", true), logrus.DebugLevel, "forced")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Logger.Info("hello")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// then: the race detector finds no data race and all entries are complete
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	a.Len(lines, 800)
+	for _, line := range lines {
+		data := map[string]interface{}{}
+		a.NoError(json.Unmarshal([]byte(line), &data), line)
+	}
+}
@@ -0,0 +1,27 @@
+package logging
+
+import "net/http"
+
+// LoggingRoundTripper is a http.RoundTripper, which logs every outgoing request as call.
+type LoggingRoundTripper struct {
+	// Base executes the requests, http.DefaultTransport is used if it is nil
+	Base http.RoundTripper
+}
+
+// NewLoggingRoundTripper returns a LoggingRoundTripper wrapping the given transport,
+// e.g. to be set as Transport of a http.Client.
+func NewLoggingRoundTripper(base http.RoundTripper) *LoggingRoundTripper {
+	return &LoggingRoundTripper{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *LoggingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := rt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := Now()
+	resp, err := base.RoundTrip(r)
+	Call(r, resp, start, err)
+	return resp, err
+}
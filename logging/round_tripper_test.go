@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoggingRoundTripper(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+
+	// and a client with the round tripper
+	client := &http.Client{Transport: NewLoggingRoundTripper(nil)}
+
+	// when: a request is sent
+	resp, err := client.Get(server.URL + "/foo?q=bar")
+	a.NoError(err)
+	resp.Body.Close()
+
+	// then: the call is logged
+	data := logRecordFromBuffer(b)
+	a.Equal("call", data.Type)
+	a.Equal("GET", data.Method)
+	a.Equal("/foo?q=bar", data.URL)
+	a.Equal(404, data.ResponseStatus)
+	a.Equal("warning", data.Level)
+}
+
+func Test_LoggingRoundTripper_Error(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a server, which is not reachable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	// when: a request is sent
+	client := &http.Client{Transport: NewLoggingRoundTripper(http.DefaultTransport)}
+	_, err := client.Get(server.URL + "/foo")
+
+	// then: the error is returned and logged
+	a.Error(err)
+	data := logRecordFromBuffer(b)
+	a.Equal("call", data.Type)
+	a.Equal("error", data.Level)
+	a.NotEmpty(data.Error)
+}
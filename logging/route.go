@@ -0,0 +1,30 @@
+package logging
+
+import "context"
+
+type routeKey struct{}
+
+// ContextWithRoute returns a context carrying the matched route template (e.g. /users/{id}),
+// which is logged as route instead of the raw path with its unbounded cardinality.
+// Routers inside of the LogMiddleware should call it on the request context, so that the route is logged by it.
+func ContextWithRoute(ctx context.Context, tmpl string) context.Context {
+	if lc := getLogContext(ctx); lc != nil {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		lc.route = tmpl
+	}
+	return context.WithValue(ctx, routeKey{}, tmpl)
+}
+
+// getRoute returns the route template of the request context or an empty string, if no route is set
+func getRoute(ctx context.Context) string {
+	if route, ok := ctx.Value(routeKey{}).(string); ok {
+		return route
+	}
+	if lc := getLogContext(ctx); lc != nil {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		return lc.route
+	}
+	return ""
+}
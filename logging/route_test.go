@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Route(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: an access with a route template is logged
+	r, _ := http.NewRequest("GET", "http://www.example.org/users/123", nil)
+	Access(r.WithContext(ContextWithRoute(r.Context(), "/users/{id}")), time.Now(), 200)
+
+	// then: the route is logged
+	data := mapFromBuffer(b)
+	a.Equal("/users/{id}", data["route"])
+	a.Equal("/users/123", data["url"])
+
+	// when: an access without a route template is logged
+	b.Reset()
+	Access(r, time.Now(), 200)
+
+	// then: no route is logged
+	a.NotContains(mapFromBuffer(b), "route")
+}
+
+func Test_Route_LogMiddleware(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a router setting the route template inside of the middleware
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(ContextWithRoute(r.Context(), "/users/{id}/orders/{oid}"))
+	}))
+
+	// when: a request is served
+	r, _ := http.NewRequest("GET", "http://www.example.org/users/123/orders/456", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: the route is logged
+	a.Equal("/users/{id}/orders/{oid}", mapFromBuffer(b)["route"])
+}
@@ -0,0 +1,92 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewSlogHandler returns a slog.Handler writing the records as application logs,
+// pre-filled with the correlation ids out of the supplied request.
+// Attributes of groups are logged with the group names as dot separated prefix.
+func NewSlogHandler(h http.Header) slog.Handler {
+	return &slogHandler{entry: Application(h)}
+}
+
+type slogHandler struct {
+	entry  *logrus.Entry
+	prefix string
+}
+
+func (sh *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return sh.entry.Logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (sh *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := logrus.Fields{}
+	record.Attrs(func(attr slog.Attr) bool {
+		addSlogAttr(fields, sh.prefix, attr)
+		return true
+	})
+
+	e := sh.entry.WithFields(fields)
+	if !record.Time.IsZero() {
+		e = e.WithTime(record.Time)
+	}
+	e.Log(logrusLevel(record.Level), record.Message)
+	return nil
+}
+
+func (sh *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := logrus.Fields{}
+	for _, attr := range attrs {
+		addSlogAttr(fields, sh.prefix, attr)
+	}
+	return &slogHandler{entry: sh.entry.WithFields(fields), prefix: sh.prefix}
+}
+
+func (sh *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return sh
+	}
+	return &slogHandler{entry: sh.entry, prefix: sh.prefix + name + "."}
+}
+
+func addSlogAttr(fields logrus.Fields, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			addSlogAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+
+	fields[prefix+attr.Key] = attr.Value.Any()
+}
+
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	case level >= slog.LevelDebug:
+		return logrus.DebugLevel
+	}
+	return logrus.TraceLevel
+}
@@ -0,0 +1,58 @@
+//go:build go1.21
+// +build go1.21
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SlogHandler(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a slog logger for a request
+	header := http.Header{
+		CorrelationIdHeader: {"correlation-123"},
+	}
+	log := slog.New(NewSlogHandler(header))
+
+	// when: a record with attributes and groups is logged
+	log.With("user", "u1").
+		WithGroup("req").
+		Warn("hello", "path", "/foo", slog.Group("client", "ip", "1.2.3.4"), slog.Int("count", 3))
+
+	// then: it is logged as application log
+	data := mapFromBuffer(b)
+	a.Equal("warning", data["level"])
+	a.Equal("hello", data["message"])
+	a.Equal("application", data["type"])
+	a.Equal("correlation-123", data["correlation_id"])
+	a.Equal("u1", data["user"])
+	a.Equal("/foo", data["req.path"])
+	a.Equal("1.2.3.4", data["req.client.ip"])
+	a.Equal(3.0, data["req.count"])
+}
+
+func Test_SlogHandler_Enabled(t *testing.T) {
+	a := assert.New(t)
+
+	// given an info logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+	log := slog.New(NewSlogHandler(http.Header{}))
+
+	// when: a debug record is logged
+	log.Debug("ignored")
+
+	// then: it is ignored
+	a.Equal("", b.String())
+}
@@ -0,0 +1,26 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logging
+
+import (
+	"log/syslog"
+
+	logrus_syslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// SetSyslog additionally sends all log entries to the syslog daemon at raddr using the given facility.
+// The logrus levels are mapped to the syslog severities, so that e.g. access logs with a 5xx status
+// are sent as err and those with a 4xx status as warning.
+// An empty network connects to the local syslog server.
+// As Set removes the hooks, SetSyslog has to be called after Set.
+func SetSyslog(network, raddr string, facility syslog.Priority, tag string) error {
+	hook, err := logrus_syslog.NewSyslogHook(network, raddr, facility, tag)
+	if err != nil {
+		return err
+	}
+	setMu.Lock()
+	defer setMu.Unlock()
+	GetLogger().Logger.AddHook(hook)
+	return nil
+}
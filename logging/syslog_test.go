@@ -0,0 +1,41 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logging
+
+import (
+	"bytes"
+	"log/syslog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SetSyslog(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a syslog server
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	a.NoError(err)
+	defer conn.Close()
+
+	// and a logger sending to syslog
+	a.NoError(SetSyslog("udp", conn.LocalAddr().String(), syslog.LOG_LOCAL0, "test"))
+	defer Set("info", false)
+	SetOutput(bytes.NewBuffer(nil))
+
+	// when: an access with status 500 is logged
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	Access(r, time.Now(), 500)
+
+	// then: it is sent with facility local0 and severity err
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	a.NoError(err)
+	a.Regexp(`^<131>`, string(buf[:n]))
+	a.Contains(string(buf[:n]), `"response_status":500`)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// TestSink captures the json log entries in memory, so that tests can make assertions on them.
+type TestSink struct {
+	mu       sync.Mutex
+	buffer   bytes.Buffer
+	previous io.Writer
+}
+
+// NewTestSink creates a TestSink and installs it as output of the Logger.
+// The previous output is restored by Close.
+func NewTestSink() *TestSink {
+	sink := &TestSink{}
+	sink.previous = SetOutput(sink)
+	return sink
+}
+
+// Write implements io.Writer
+func (sink *TestSink) Write(p []byte) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.buffer.Write(p)
+}
+
+// Records returns all captured log entries in the order they were written.
+// Entries which are not json formatted are skipped.
+func (sink *TestSink) Records() []map[string]interface{} {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(sink.buffer.Bytes(), []byte("\n")) {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(line, &record); err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// LastRecord returns the last captured log entry or nil, if nothing was logged.
+func (sink *TestSink) LastRecord() map[string]interface{} {
+	records := sink.Records()
+	if len(records) == 0 {
+		return nil
+	}
+	return records[len(records)-1]
+}
+
+// Reset discards the captured log entries
+func (sink *TestSink) Reset() {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.buffer.Reset()
+}
+
+// Close restores the previous output of the Logger
+func (sink *TestSink) Close() {
+	SetOutput(sink.previous)
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TestSink(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a test sink
+	sink := NewTestSink()
+	defer sink.Close()
+
+	// then: nothing is captured
+	a.Empty(sink.Records())
+	a.Nil(sink.LastRecord())
+
+	// when: multiple entries are logged
+	Logger.Info("first")
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), r)
+
+	// then: all are captured in order
+	records := sink.Records()
+	a.Len(records, 2)
+	a.Equal("first", records[0]["message"])
+	a.Equal("access", records[1]["type"])
+	a.Equal(records[1], sink.LastRecord())
+
+	// when: the sink is reset
+	sink.Reset()
+
+	// then: the records are discarded
+	a.Empty(sink.Records())
+}
+
+func Test_TestSink_Close(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a test sink
+	previous := logOutput.out
+	sink := NewTestSink()
+
+	// when: it is closed
+	sink.Close()
+
+	// then: the previous output is restored
+	a.Equal(previous, logOutput.out)
+}
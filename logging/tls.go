@@ -0,0 +1,21 @@
+package logging
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsVersionName returns the human readable name of a tls version
+func tlsVersionName(version uint16) string {
+	if name, exists := tlsVersionNames[version]; exists {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
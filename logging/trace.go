@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// getTraceIds returns the OpenTelemetry trace and span id of the span in the context.
+// Empty strings are returned if the context has no valid span.
+func getTraceIds(ctx context.Context) (traceId, spanId string) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", ""
+	}
+	return sc.TraceID().String(), sc.SpanID().String()
+}
+
+func setTraceIds(fields logrus.Fields, traceId, spanId string) {
+	if traceId != "" {
+		fields["trace_id"] = traceId
+	}
+	if spanId != "" {
+		fields["span_id"] = spanId
+	}
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func contextWithSpan() context.Context {
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+	})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func Test_TraceIds(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// and a request with an active span
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r = r.WithContext(contextWithSpan())
+
+	// when: an access is logged
+	Access(r, time.Now(), 200)
+
+	// then: the trace ids are logged
+	data := mapFromBuffer(b)
+	a.Equal("4bf92f3577b34da6a3ce929d0e0e4736", data["trace_id"])
+	a.Equal("00f067aa0ba902b7", data["span_id"])
+
+	// when: a call is logged
+	b.Reset()
+	Call(r, &http.Response{StatusCode: 200, Header: http.Header{}}, time.Now(), nil)
+
+	// then: the trace ids are logged
+	data = mapFromBuffer(b)
+	a.Equal("4bf92f3577b34da6a3ce929d0e0e4736", data["trace_id"])
+	a.Equal("00f067aa0ba902b7", data["span_id"])
+
+	// when: an application log is written
+	entry := ApplicationWithContext(r.Context(), r.Header)
+
+	// then: the trace ids are contained
+	a.Equal("4bf92f3577b34da6a3ce929d0e0e4736", entry.Data["trace_id"])
+	a.Equal("00f067aa0ba902b7", entry.Data["span_id"])
+}
+
+func Test_TraceIds_NoSpan(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: an access without span is logged
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	Access(r, time.Now(), 200)
+
+	// then: no trace ids are logged
+	data := mapFromBuffer(b)
+	a.NotContains(data, "trace_id")
+	a.NotContains(data, "span_id")
+}
@@ -0,0 +1,43 @@
+package logging
+
+import "github.com/sirupsen/logrus"
+
+// SetTypeFormatter configures a distinct formatter for the entries of the given log type (e.g. "access").
+// Entries of other types are formatted by the formatter configured in Set.
+// As Set resets the formatter, SetTypeFormatter has to be called after Set.
+func SetTypeFormatter(logType string, typeFormat logrus.Formatter) {
+	setMu.Lock()
+	defer setMu.Unlock()
+	formatter := currentFormatter()
+	tf := &typeFormatter{
+		defaultFormatter: formatter,
+		formatters:       map[string]logrus.Formatter{},
+	}
+	if current, ok := formatter.(*typeFormatter); ok {
+		tf.defaultFormatter = current.defaultFormatter
+		for t, f := range current.formatters {
+			tf.formatters[t] = f
+		}
+	}
+	tf.formatters[logType] = typeFormat
+
+	logOutput.mu.Lock()
+	defer logOutput.mu.Unlock()
+	logOutput.formatter = tf
+}
+
+// typeFormatter dispatches the formatting by the type field of an entry.
+// It is replaced as a whole on changes, so that it is safe for concurrent use.
+type typeFormatter struct {
+	defaultFormatter logrus.Formatter
+	formatters       map[string]logrus.Formatter
+}
+
+func (tf *typeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if logType, ok := entry.Data["type"].(string); ok {
+		if f, exists := tf.formatters[logType]; exists {
+			return f.Format(entry)
+		}
+	}
+	return tf.defaultFormatter.Format(entry)
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SetTypeFormatter(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger with a text formatter for access logs
+	defer Set("info", false)
+	SetTypeFormatter("access", &logrus.TextFormatter{DisableColors: true})
+	b := bytes.NewBuffer(nil)
+	SetOutput(b)
+
+	// when: an access log is written
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	Access(r, time.Now(), 200)
+
+	// then: it is text formatted
+	a.Regexp(`^time=.* level=info msg="200 ->GET /foo"`, b.String())
+
+	// when: an application log is written
+	b.Reset()
+	Application(http.Header{}).Info("hello")
+
+	// then: it is still json formatted
+	data := mapFromBuffer(b)
+	a.Equal("application", data["type"])
+	a.Equal("hello", data["message"])
+}
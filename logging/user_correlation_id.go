@@ -1,6 +1,9 @@
 package logging
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 var UserCorrelationIdHeader = "X-User-Correlation-Id"
 
@@ -8,3 +11,17 @@ var UserCorrelationIdHeader = "X-User-Correlation-Id"
 func GetUserCorrelationId(h http.Header) string {
 	return h.Get(UserCorrelationIdHeader)
 }
+
+type userCorrelationIdKey struct{}
+
+// ContextWithUserCorrelationId returns a context carrying the user correlation id.
+// The LogMiddleware adds the user correlation id of the request to its context.
+func ContextWithUserCorrelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userCorrelationIdKey{}, id)
+}
+
+// UserCorrelationIdFromContext returns the user correlation id of the context or an empty string, if none is set.
+func UserCorrelationIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(userCorrelationIdKey{}).(string)
+	return id
+}